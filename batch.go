@@ -0,0 +1,385 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"sort"
+	"strconv"
+
+	"github.com/jessevdk/go-flags"
+	log "github.com/sirupsen/logrus"
+	"github.com/solovev/orange-app-runner/checker"
+	"github.com/solovev/orange-app-runner/instance"
+	"golang.org/x/sys/unix"
+)
+
+// batchOptions - параметры режима "oar batch".
+type batchOptions struct {
+	TestsDir      string `long:"tests" description:"Set path to the directory with tests: ejudge (\"001.dat\", \"001.ans\") or Polygon (\"01\", \"01.a\") naming"`
+	OutputDir     string `long:"output-dir" description:"Set path to the directory for outputs of the program (\"<test>.out\", \"<test>.err\"), by default a temporary directory is created"`
+	Check         bool   `long:"check" description:"Compare outputs with answers (see --check-mode and --checker)"`
+	StopOnFailure bool   `long:"stop-on-failure" description:"Stop at the first failed test"`
+	NoAnswers     bool   `long:"no-answers" description:"Accept tests without answer files with --check, their outputs are not checked"`
+
+	// Tracer - служебный параметр, с которым oar запускает tracer для пакетного запуска.
+	Tracer bool `long:"batch-tracer" hidden:"yes" description:"Run tests received from the parent process"`
+}
+
+var (
+	ejudgeTestName  = regexp.MustCompile(`^(\d+)\.dat$`)
+	polygonTestName = regexp.MustCompile(`^(\d+)$`)
+)
+
+// batchTest - тест пакетного запуска, пути указаны относительно каталога тестов.
+type batchTest struct {
+	Name   string `json:"name"`
+	Input  string `json:"input"`
+	Answer string `json:"answer,omitempty"`
+}
+
+// batchTask - задание, которое oar передает tracer'у через batchFd.
+type batchTask struct {
+	TestsDir  string      `json:"tests_dir"`
+	OutputDir string      `json:"output_dir"`
+	Tests     []batchTest `json:"tests"`
+}
+
+// batchTestReport - отчет о запуске программы на одном тесте.
+type batchTestReport struct {
+	Name   string `json:"name"`
+	Output string `json:"output"`
+	*instance.Report
+}
+
+// batchReport - итоговый отчет пакетного запуска. Вердикт - первый неуспешный вердикт теста.
+type batchReport struct {
+	Verdict instance.Verdict      `json:"verdict"`
+	Total   int                   `json:"total"`
+	Passed  int                   `json:"passed"`
+	Error   string                `json:"error,omitempty"`
+	Tests   []*batchTestReport    `json:"tests"`
+	Cgroup  *instance.CgroupStats `json:"cgroup,omitempty"`
+}
+
+// runBatch реализует режим "oar batch --tests <dir> [<options>] <program> [<parameters>]":
+// программа запускается на всех тестах в одном tracer'е.
+func runBatch(args []string) int {
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+
+	parser := newParser()
+	parser.Usage = "batch --tests <dir> [<options>] <program> [<parameters>]"
+	parser.Group.Find("Batch mode").Hidden = false
+
+	rest, err := parser.ParseArgs(args)
+	if err != nil {
+		if flagsErr, ok := err.(*flags.Error); ok && flagsErr.Type == flags.ErrHelp {
+			return 0
+		}
+		return 1
+	}
+	applyArgs(rest)
+
+	if len(batchCfg.TestsDir) == 0 {
+		log.Errorln("Option \"--tests\" is required in batch mode")
+		return 1
+	}
+	if len(interactorCfg.InteractorPath) > 0 {
+		log.Errorln("Option \"--interactor\" is not supported in batch mode")
+		return 1
+	}
+	if err := checkChecker(); err != nil {
+		log.Errorln(err)
+		return 1
+	}
+	checkConfig()
+
+	task, err := prepareBatch()
+	if err != nil {
+		log.Errorf("Unable to prepare batch run: %v\n", err)
+		return 1
+	}
+	log.Infof("Running %d tests from \"%s\", outputs are written to \"%s\"\n", len(task.Tests), task.TestsDir, task.OutputDir)
+
+	controlReader, controlWriter, err := os.Pipe()
+	if err != nil {
+		log.Errorf("Error creating batch pipe: %v\n", err)
+		return 1
+	}
+
+	aggregate := &batchReport{Verdict: instance.VerdictOK, Total: len(task.Tests)}
+	readReports := func(r io.Reader) (*instance.Report, error) {
+		defer controlWriter.Close()
+		return receiveBatchReports(task, aggregate, r, controlWriter)
+	}
+
+	tracerArgs := insertTracerOptions(args, "--batch-tracer")
+	_, summary := startSandbox(&cfg, processPath, tracerArgs, sandboxOptions{
+		report:     true,
+		batch:      controlReader,
+		readReport: readReports,
+	})
+
+	aggregate.Cgroup = summary.Cgroup
+	if summary.Verdict == instance.VerdictInternalError {
+		aggregate.Verdict = summary.Verdict
+		aggregate.Error = summary.Error
+	}
+
+	if err := writeJSON(cfg.ReportPath, aggregate); err != nil {
+		log.Errorf("Unable to write batch report: %v\n", err)
+		return 1
+	}
+
+	if aggregate.Verdict != instance.VerdictOK {
+		return 1
+	}
+	return 0
+}
+
+// prepareBatch ищет тесты и создает каталог для вывода программы.
+func prepareBatch() (*batchTask, error) {
+	testsDir, err := filepath.Abs(batchCfg.TestsDir)
+	if err != nil {
+		return nil, err
+	}
+
+	tests, err := findTests(testsDir)
+	if err != nil {
+		return nil, err
+	}
+
+	outputDir := batchCfg.OutputDir
+	if len(outputDir) == 0 {
+		if outputDir, err = ioutil.TempDir("", "oar-batch-"); err != nil {
+			return nil, err
+		}
+	} else if err = os.MkdirAll(outputDir, 0755); err != nil {
+		return nil, err
+	}
+
+	if outputDir, err = filepath.Abs(outputDir); err != nil {
+		return nil, err
+	}
+
+	return &batchTask{TestsDir: testsDir, OutputDir: outputDir, Tests: tests}, nil
+}
+
+// findTests ищет в каталоге <dir> тесты "<N>.dat" с ответами "<N>.ans" (ejudge)
+// или "<N>" с ответами "<N>.a" (Polygon). Тесты упорядочиваются по номеру. Смешивать
+// форматы нельзя: вывод тестов "01.dat" и "01" попал бы в один файл "01.out".
+func findTests(dir string) ([]batchTest, error) {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	files := make(map[string]bool)
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			files[entry.Name()] = true
+		}
+	}
+
+	var tests []batchTest
+	ejudge, polygon := false, false
+	for name := range files {
+		var test batchTest
+		if m := ejudgeTestName.FindStringSubmatch(name); m != nil {
+			test = batchTest{Name: m[1], Input: name, Answer: m[1] + ".ans"}
+			ejudge = true
+		} else if m := polygonTestName.FindStringSubmatch(name); m != nil {
+			test = batchTest{Name: m[1], Input: name, Answer: name + ".a"}
+			polygon = true
+		} else {
+			continue
+		}
+
+		if !files[test.Answer] {
+			test.Answer = ""
+		}
+		tests = append(tests, test)
+	}
+
+	if len(tests) == 0 {
+		return nil, fmt.Errorf("No tests found in \"%s\"", dir)
+	}
+	if ejudge && polygon {
+		return nil, fmt.Errorf("Tests in \"%s\" mix ejudge (\"001.dat\") and Polygon (\"01\") naming", dir)
+	}
+
+	sort.Slice(tests, func(i, j int) bool {
+		a, _ := strconv.Atoi(tests[i].Name)
+		b, _ := strconv.Atoi(tests[j].Name)
+		if a != b {
+			return a < b
+		}
+		return tests[i].Input < tests[j].Input
+	})
+	return tests, nil
+}
+
+// receiveBatchReports читает из <r> отчеты tracer'а по каждому тесту, проверяет вывод программы
+// и сообщает tracer'у через <control>, продолжать ли запуск. Возвращает отчет о работе tracer'а.
+func receiveBatchReports(task *batchTask, aggregate *batchReport, r io.Reader, control io.Writer) (*instance.Report, error) {
+	encoder := json.NewEncoder(control)
+	if err := encoder.Encode(task); err != nil {
+		return nil, err
+	}
+
+	decoder := json.NewDecoder(r)
+	for _, test := range task.Tests {
+		report := &instance.Report{}
+		if err := decoder.Decode(report); err != nil {
+			return nil, fmt.Errorf("Unable to receive report for test %s: %v", test.Name, err)
+		}
+
+		output := filepath.Join(task.OutputDir, test.Name+".out")
+		if batchCfg.Check || len(answerCfg.CheckerPath) > 0 {
+			checkBatchTest(task, test, report, output)
+		}
+		log.Infof("Test %s: %s (%.0fms, %dKB)\n", test.Name, report.Verdict, report.UserTime+report.SystemTime, report.PeakRSS)
+
+		aggregate.Tests = append(aggregate.Tests, &batchTestReport{Name: test.Name, Output: output, Report: report})
+		if report.Verdict == instance.VerdictOK {
+			aggregate.Passed++
+		} else if aggregate.Verdict == instance.VerdictOK {
+			aggregate.Verdict = report.Verdict
+		}
+
+		next := report.Verdict == instance.VerdictOK || !batchCfg.StopOnFailure
+		if err := encoder.Encode(next); err != nil {
+			return nil, err
+		}
+		if !next {
+			break
+		}
+	}
+	return &instance.Report{Verdict: aggregate.Verdict, ExitStatus: -1}, nil
+}
+
+func checkBatchTest(task *batchTask, test batchTest, report *instance.Report, output string) {
+	if report.Verdict != instance.VerdictOK {
+		return
+	}
+	if len(test.Answer) == 0 {
+		if batchCfg.NoAnswers {
+			log.Warnf("Test %s has no answer file, output is not checked\n", test.Name)
+			return
+		}
+		result := &checker.Result{
+			Verdict: checker.VerdictFail,
+			Message: fmt.Sprintf("Test %s has no answer file", test.Name),
+		}
+		setCheckResult(report, result, nil)
+		return
+	}
+
+	input := filepath.Join(task.TestsDir, test.Input)
+	answer := filepath.Join(task.TestsDir, test.Answer)
+	result, err := checkAnswer(input, output, answer)
+	if err != nil {
+		log.Errorf("Unable to check output of test %s: %v\n", test.Name, err)
+	}
+	setCheckResult(report, result, err)
+}
+
+// batchTracer выполняет пакетный запуск внутри tracer'а: запускает программу на каждом тесте,
+// отправляет отчет родительскому процессу и ждет от него команды продолжения.
+type batchTracer struct {
+	task      batchTask
+	testsDir  *os.File
+	outputDir *os.File
+	control   *json.Decoder
+}
+
+// openBatch читает задание из <control> и открывает каталоги тестов и вывода.
+// Должна вызываться до pivot_root, чтобы пути разрешались относительно хоста.
+func openBatch(control *os.File) (*batchTracer, error) {
+	b := &batchTracer{control: json.NewDecoder(control)}
+	if err := b.control.Decode(&b.task); err != nil {
+		return nil, fmt.Errorf("Unable to receive batch task: %v", err)
+	}
+
+	var err error
+	if b.testsDir, err = os.Open(b.task.TestsDir); err != nil {
+		return nil, err
+	}
+	if b.outputDir, err = os.Open(b.task.OutputDir); err != nil {
+		b.testsDir.Close()
+		return nil, err
+	}
+	return b, nil
+}
+
+// run запускает программу на тестах и возвращает код выхода tracer'а.
+func (b *batchTracer) run(reportFile *os.File) int {
+	defer b.testsDir.Close()
+	defer b.outputDir.Close()
+
+	for _, test := range b.task.Tests {
+		log.Infof("Running test %s...\n", test.Name)
+
+		var report *instance.Report
+		stdio, err := b.openStdio(test)
+		if err != nil {
+			report = instance.FailedReport(err)
+		} else {
+			cfg.Stdio = stdio
+			if _, report, err = instance.Run(processPath, processArgs, &cfg); err != nil {
+				log.Warnf("Error running tracee process on test %s: %v\n", test.Name, err)
+			}
+		}
+
+		if err := report.Write(reportFile); err != nil {
+			log.Warnf("Error sending report to the parent process: %v\n", err)
+			return 1
+		}
+
+		var next bool
+		if err := b.control.Decode(&next); err != nil {
+			log.Warnf("Error receiving command from the parent process: %v\n", err)
+			return 1
+		}
+		if !next {
+			break
+		}
+	}
+	return 0
+}
+
+// openStdio открывает ввод теста и файлы для вывода программы относительно открытых каталогов.
+func (b *batchTracer) openStdio(test batchTest) ([]*os.File, error) {
+	stdin, err := openAt(b.testsDir, test.Input, unix.O_RDONLY)
+	if err != nil {
+		return nil, err
+	}
+
+	stdout, err := openAt(b.outputDir, test.Name+".out", unix.O_WRONLY|unix.O_CREAT|unix.O_TRUNC)
+	if err != nil {
+		stdin.Close()
+		return nil, err
+	}
+
+	stderr, err := openAt(b.outputDir, test.Name+".err", unix.O_WRONLY|unix.O_CREAT|unix.O_TRUNC)
+	if err != nil {
+		stdin.Close()
+		stdout.Close()
+		return nil, err
+	}
+	return []*os.File{stdin, stdout, stderr}, nil
+}
+
+func openAt(dir *os.File, name string, flag int) (*os.File, error) {
+	fd, err := unix.Openat(int(dir.Fd()), name, flag|unix.O_CLOEXEC, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to open \"%s\": %v", name, err)
+	}
+	return os.NewFile(uintptr(fd), name), nil
+}
@@ -0,0 +1,246 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/jessevdk/go-flags"
+	log "github.com/sirupsen/logrus"
+	"github.com/solovev/orange-app-runner/checker"
+	"github.com/solovev/orange-app-runner/instance"
+	"github.com/solovev/orange-app-runner/util"
+)
+
+// Коды выхода режима "oar check" (совпадают с кодами testlib).
+const (
+	checkExitOK   = 0
+	checkExitWA   = 1
+	checkExitPE   = 2
+	checkExitFail = 3
+)
+
+// Коды выхода обычного запуска с "--answer" продолжают коды TracerError.
+var runCheckExitCodes = map[checker.Verdict]int{
+	checker.VerdictWrongAnswer:       6,
+	checker.VerdictPresentationError: 7,
+	checker.VerdictFail:              8,
+}
+
+// checkOptions - параметры сравнения вывода с ответом, общие для режима "oar check"
+// и обычного запуска с "--answer".
+type checkOptions struct {
+	Mode       string  `long:"check-mode" description:"Set answer comparison mode" choice:"exact" choice:"token" choice:"float" choice:"lines" default:"token"`
+	AbsEpsilon float64 `long:"abs-eps" description:"Set absolute error allowed for numbers in the \"float\" check mode" default:"0"`
+	RelEpsilon float64 `long:"rel-eps" description:"Set relative error allowed for numbers in the \"float\" check mode" default:"0"`
+}
+
+func (o *checkOptions) options() checker.Options {
+	return checker.Options{
+		Mode:       checker.Mode(o.Mode),
+		AbsEpsilon: o.AbsEpsilon,
+		RelEpsilon: o.RelEpsilon,
+	}
+}
+
+// answerOptions - параметры проверки stdout tracee после запуска.
+type answerOptions struct {
+	AnswerFile string `long:"answer" description:"Compare tracee's stdout (--stdout) with the specified answer file after a successful run"`
+
+	CheckerPath          string  `long:"checker" description:"Check the answer with the specified testlib checker (\"<checker> <input> <output> <answer>\") run in its own sandbox instead of the built-in comparison"`
+	CheckerRealTimeLimit int64   `long:"checker-rt-limit" description:"Terminate checker after specified milliseconds" default:"10000"`
+	CheckerCPUTimeLimit  float64 `long:"checker-cput-limit" description:"Terminate checker if it has used more than specified CPU time in milliseconds" default:"-1"`
+	CheckerMemoryLimit   int64   `long:"checker-mem-limit" description:"Terminate checker if its memory consumption exceeds the specified number of kilobytes" default:"-1"`
+
+	checkOptions
+}
+
+type checkCommand struct {
+	ReportPath string `long:"report" description:"Write JSON result to the specified file instead of standard output"`
+
+	checkOptions
+
+	Args struct {
+		Output string `positional-arg-name:"output" description:"Participant's output file"`
+		Answer string `positional-arg-name:"answer" description:"Jury's answer file"`
+	} `positional-args:"yes" required:"yes"`
+}
+
+// runCheck реализует режим "oar check [<options>] <output> <answer>".
+func runCheck(args []string) int {
+	var command checkCommand
+	parser := flags.NewParser(&command, flags.Default)
+	parser.Usage = "check [<options>] <output> <answer>"
+	if _, err := parser.ParseArgs(args); err != nil {
+		if flagsErr, ok := err.(*flags.Error); ok && flagsErr.Type == flags.ErrHelp {
+			return checkExitOK
+		}
+		return checkExitFail
+	}
+
+	result, err := checker.CheckFiles(command.Args.Output, command.Args.Answer, command.options())
+	if err != nil {
+		log.Errorf("Unable to check output: %v\n", err)
+		return checkExitFail
+	}
+
+	if err := writeJSON(command.ReportPath, result); err != nil {
+		log.Errorf("Unable to write check result: %v\n", err)
+		return checkExitFail
+	}
+
+	switch result.Verdict {
+	case checker.VerdictWrongAnswer:
+		return checkExitWA
+	case checker.VerdictPresentationError:
+		return checkExitPE
+	}
+	return checkExitOK
+}
+
+// writeJSON записывает <v> в формате JSON в файл <path> или в стандартный вывод, если путь не указан.
+func writeJSON(path string, v interface{}) error {
+	f := os.Stdout
+	if len(path) > 0 {
+		var err error
+		if f, err = util.CreateFile(path); err != nil {
+			return err
+		}
+		defer f.Close()
+	}
+
+	encoder := json.NewEncoder(f)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(v)
+}
+
+// checkAnswerFile проверяет, что stdout tracee перенаправлен в файл, который можно сравнить с ответом,
+// а для внешнего чекера - что stdin читается из файла.
+func checkAnswerFile() error {
+	if len(cfg.OutputFile) == 0 || cfg.OutputFile == "-" {
+		return fmt.Errorf("Option \"--answer\" requires tracee's stdout to be redirected to a file (--stdout)")
+	}
+
+	if len(answerCfg.CheckerPath) > 0 && cfg.InputFile == "-" {
+		return fmt.Errorf("Option \"--checker\" requires tracee's stdin to be read from a file (--stdin)")
+	}
+	return checkChecker()
+}
+
+// checkChecker ищет исполняемый файл внешнего чекера, если он указан.
+func checkChecker() error {
+	if len(answerCfg.CheckerPath) == 0 {
+		return nil
+	}
+
+	path, err := util.CheckIsBinaryExists(answerCfg.CheckerPath)
+	if err != nil {
+		return fmt.Errorf("Unable to locate checker \"%s\": %v", answerCfg.CheckerPath, err)
+	}
+	answerCfg.CheckerPath = path
+	return nil
+}
+
+// checkOutput сравнивает stdout tracee с ответом, если запуск завершился успешно,
+// дополняет отчет <report> результатом проверки и возвращает итоговый код выхода.
+func checkOutput(report *instance.Report, exitCode int) int {
+	if exitCode != 0 || (report != nil && report.Verdict != instance.VerdictOK) {
+		return exitCode
+	}
+
+	result, err := checkAnswer(cfg.InputFile, cfg.OutputFile, answerCfg.AnswerFile)
+	if report != nil {
+		setCheckResult(report, result, err)
+	}
+	if err != nil {
+		log.Errorf("Unable to check output: %v\n", err)
+		return 1
+	}
+	log.Infof("Check result: %s %s\n", result.Verdict, result.Message)
+
+	if code, ok := runCheckExitCodes[result.Verdict]; ok {
+		return code
+	}
+	return exitCode
+}
+
+// checkAnswer сравнивает вывод <output> с ответом <answer> внешним чекером (--checker),
+// если он указан, иначе встроенным.
+func checkAnswer(input, output, answer string) (*checker.Result, error) {
+	if len(answerCfg.CheckerPath) > 0 {
+		return runExternalChecker(input, output, answer)
+	}
+	return checker.CheckFiles(output, answer, answerCfg.options())
+}
+
+// setCheckResult переносит в отчет <report> результат проверки или ошибку чекера.
+func setCheckResult(report *instance.Report, result *checker.Result, err error) {
+	if err != nil {
+		report.Verdict = instance.VerdictInternalError
+		report.Tag = "checker"
+		report.Error = err.Error()
+		return
+	}
+	report.Check = result
+	report.Verdict = instance.Verdict(result.Verdict)
+}
+
+// runExternalChecker запускает testlib чекер в отдельной песочнице с собственными ограничениями.
+// Stdout чекера отбрасывается, комментарий читается из его stderr.
+func runExternalChecker(input, output, answer string) (*checker.Result, error) {
+	files := []string{input, output, answer}
+	for i, path := range files {
+		abs, err := filepath.Abs(path)
+		if err != nil {
+			return nil, err
+		}
+		files[i] = abs
+	}
+
+	comment, err := ioutil.TempFile("", "oar-checker-")
+	if err != nil {
+		return nil, err
+	}
+	comment.Close()
+	defer os.Remove(comment.Name())
+
+	args := []string{
+		"--stdin=/dev/null",
+		"--stdout=/dev/null",
+		"--stderr=" + comment.Name(),
+		"--report=-",
+		fmt.Sprintf("--rt-limit=%d", answerCfg.CheckerRealTimeLimit),
+		fmt.Sprintf("--cput-limit=%v", answerCfg.CheckerCPUTimeLimit),
+		fmt.Sprintf("--mem-limit=%d", answerCfg.CheckerMemoryLimit),
+	}
+	if cfg.Debug {
+		args = append(args, "--debug")
+	}
+	args = append(args, "--", answerCfg.CheckerPath)
+	args = append(args, files...)
+
+	_, report := startSandbox(&instance.Config{}, answerCfg.CheckerPath, args, sandboxOptions{report: true})
+	log.Debugf("Checker report: %+v\n", report)
+
+	data, err := ioutil.ReadFile(comment.Name())
+	if err != nil {
+		return nil, err
+	}
+	return sandboxedTestlibResult("Checker", report, string(data)), nil
+}
+
+// sandboxedTestlibResult переводит отчет о запуске testlib программы <name> (чекера или интерактора)
+// в результат проверки. Если программа не завершилась сама (превысила ограничения, была убита
+// сигналом), то результат - FAIL.
+func sandboxedTestlibResult(name string, report *instance.Report, comment string) *checker.Result {
+	exited := report.Verdict == instance.VerdictOK || (report.Verdict == instance.VerdictRuntimeError && report.Signal == 0)
+	if !exited || report.ExitStatus < 0 {
+		return &checker.Result{
+			Verdict: checker.VerdictFail,
+			Message: fmt.Sprintf("%s run failed (%s): %s", name, report.Verdict, report.Error),
+		}
+	}
+	return checker.TestlibResult(report.ExitStatus, comment)
+}
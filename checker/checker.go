@@ -0,0 +1,113 @@
+package checker
+
+import (
+	"fmt"
+	"io"
+	"os"
+)
+
+// Mode - способ сравнения вывода участника с ответом жюри.
+type Mode string
+
+const (
+	// ModeExact - побайтовое сравнение.
+	ModeExact Mode = "exact"
+	// ModeToken - сравнение последовательностей слов, разделенных пробельными символами.
+	ModeToken Mode = "token"
+	// ModeFloat - сравнение слов, числа сравниваются с абсолютной и относительной погрешностью.
+	ModeFloat Mode = "float"
+	// ModeLines - сравнение множеств строк без учета их порядка.
+	ModeLines Mode = "lines"
+)
+
+type Verdict string
+
+const (
+	VerdictOK                Verdict = "OK"
+	VerdictWrongAnswer       Verdict = "WA"
+	VerdictPresentationError Verdict = "PE"
+	// VerdictFail - проверка не удалась по вине чекера или жюри.
+	VerdictFail Verdict = "FAIL"
+	// VerdictPoints - чекер оценил ответ баллами (Result.Points).
+	VerdictPoints Verdict = "PT"
+)
+
+// Options содержит параметры сравнения. Число из вывода участника считается верным,
+// если оно отличается от ответа не более чем на <AbsEpsilon> или не более чем
+// на <RelEpsilon> * |ответ|.
+type Options struct {
+	Mode       Mode
+	AbsEpsilon float64
+	RelEpsilon float64
+}
+
+// Position указывает на место в выводе участника. Строки и столбцы нумеруются с 1,
+// смещение - с 0, <Token> - порядковый номер слова (для режимов, сравнивающих слова).
+type Position struct {
+	Line   int   `json:"line"`
+	Column int   `json:"column"`
+	Offset int64 `json:"offset"`
+	Token  int   `json:"token,omitempty"`
+}
+
+func (p Position) String() string {
+	return fmt.Sprintf("line %d, column %d", p.Line, p.Column)
+}
+
+// Result - результат проверки с позицией первого расхождения.
+type Result struct {
+	Verdict  Verdict   `json:"verdict"`
+	Message  string    `json:"message,omitempty"`
+	Position *Position `json:"position,omitempty"`
+	Points   *float64  `json:"points,omitempty"`
+}
+
+// Modes возвращает поддерживаемые режимы сравнения.
+func Modes() []Mode {
+	return []Mode{ModeExact, ModeToken, ModeFloat, ModeLines}
+}
+
+// Check сравнивает вывод участника <output> с ответом <answer>.
+// Для режима "exact" потоки перечитываются повторно, чтобы отличить PE от WA.
+func Check(output, answer io.ReadSeeker, opts Options) (*Result, error) {
+	switch opts.Mode {
+	case ModeExact:
+		return checkExact(output, answer)
+	case ModeToken, "":
+		return checkTokens(output, answer, opts, compareToken)
+	case ModeFloat:
+		return checkTokens(output, answer, opts, compareFloat)
+	case ModeLines:
+		return checkLines(output, answer)
+	}
+	return nil, fmt.Errorf("Unknown check mode \"%s\"", opts.Mode)
+}
+
+// CheckFiles сравнивает файл с выводом участника <outputPath> с файлом ответа <answerPath>.
+func CheckFiles(outputPath, answerPath string, opts Options) (*Result, error) {
+	output, err := os.Open(outputPath)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to open output file: %v", err)
+	}
+	defer output.Close()
+
+	answer, err := os.Open(answerPath)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to open answer file: %v", err)
+	}
+	defer answer.Close()
+
+	return Check(output, answer, opts)
+}
+
+func accepted() *Result {
+	return &Result{Verdict: VerdictOK}
+}
+
+func mismatch(verdict Verdict, pos Position, format string, a ...interface{}) *Result {
+	return &Result{
+		Verdict:  verdict,
+		Message:  fmt.Sprintf(format, a...) + " at " + pos.String(),
+		Position: &pos,
+	}
+}
@@ -0,0 +1,242 @@
+package checker
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCheck(t *testing.T) {
+	tests := []struct {
+		name     string
+		opts     Options
+		output   string
+		answer   string
+		verdict  Verdict
+		position *Position
+	}{
+		{
+			name:    "exact match",
+			opts:    Options{Mode: ModeExact},
+			output:  "1 2\n3\n",
+			answer:  "1 2\n3\n",
+			verdict: VerdictOK,
+		},
+		{
+			name:     "exact trailing newline",
+			opts:     Options{Mode: ModeExact},
+			output:   "1 2\n",
+			answer:   "1 2",
+			verdict:  VerdictPresentationError,
+			position: &Position{Line: 1, Column: 4, Offset: 3},
+		},
+		{
+			name:     "exact trailing whitespace",
+			opts:     Options{Mode: ModeExact},
+			output:   "1 2 \n3\n",
+			answer:   "1 2\n3\n",
+			verdict:  VerdictPresentationError,
+			position: &Position{Line: 1, Column: 4, Offset: 3},
+		},
+		{
+			name:     "exact missing newline",
+			opts:     Options{Mode: ModeExact},
+			output:   "1\n2",
+			answer:   "1\n2\n",
+			verdict:  VerdictPresentationError,
+			position: &Position{Line: 2, Column: 2, Offset: 3},
+		},
+		{
+			name:     "exact wrong answer",
+			opts:     Options{Mode: ModeExact},
+			output:   "1 3\n",
+			answer:   "1 2\n",
+			verdict:  VerdictWrongAnswer,
+			position: &Position{Line: 1, Column: 3, Offset: 2},
+		},
+		{
+			name:     "exact empty answer",
+			opts:     Options{Mode: ModeExact},
+			output:   "5\n",
+			answer:   "",
+			verdict:  VerdictWrongAnswer,
+			position: &Position{Line: 1, Column: 1, Offset: 0},
+		},
+		{
+			name:    "token ignores whitespace",
+			opts:    Options{Mode: ModeToken},
+			output:  "1  2\r\n3 \n\n",
+			answer:  "1 2\n3",
+			verdict: VerdictOK,
+		},
+		{
+			name:     "token mismatch position",
+			opts:     Options{Mode: ModeToken},
+			output:   "1\n2 5\n",
+			answer:   "1\n2 3\n",
+			verdict:  VerdictWrongAnswer,
+			position: &Position{Line: 2, Column: 3, Offset: 4, Token: 3},
+		},
+		{
+			name:     "token empty answer",
+			opts:     Options{Mode: ModeToken},
+			output:   "5\n",
+			answer:   "",
+			verdict:  VerdictWrongAnswer,
+			position: &Position{Line: 1, Column: 1, Offset: 0, Token: 1},
+		},
+		{
+			name:     "token empty output",
+			opts:     Options{Mode: ModeToken},
+			output:   "\n",
+			answer:   "5\n",
+			verdict:  VerdictWrongAnswer,
+			position: &Position{Line: 2, Column: 1, Offset: 1, Token: 1},
+		},
+		{
+			name:    "token empty answer and whitespace output",
+			opts:    Options{Mode: ModeToken},
+			output:  " \n",
+			answer:  "",
+			verdict: VerdictOK,
+		},
+		{
+			name:    "float within absolute error",
+			opts:    Options{Mode: ModeFloat, AbsEpsilon: 1e-6},
+			output:  "1.0000001 x\n",
+			answer:  "1 x\n",
+			verdict: VerdictOK,
+		},
+		{
+			name:    "float within relative error",
+			opts:    Options{Mode: ModeFloat, RelEpsilon: 1e-3},
+			output:  "1000.5\n",
+			answer:  "1000\n",
+			verdict: VerdictOK,
+		},
+		{
+			name:     "float outside error",
+			opts:     Options{Mode: ModeFloat, AbsEpsilon: 1e-6},
+			output:   "1 1.1\n",
+			answer:   "1 1\n",
+			verdict:  VerdictWrongAnswer,
+			position: &Position{Line: 1, Column: 3, Offset: 2, Token: 2},
+		},
+		{
+			name:     "float non-number token",
+			opts:     Options{Mode: ModeFloat, AbsEpsilon: 1e-6},
+			output:   "1.5 abc\n",
+			answer:   "1.5 2.5\n",
+			verdict:  VerdictPresentationError,
+			position: &Position{Line: 1, Column: 5, Offset: 4, Token: 2},
+		},
+		{
+			name:     "float word in answer",
+			opts:     Options{Mode: ModeFloat, AbsEpsilon: 1e-6},
+			output:   "NO\n",
+			answer:   "YES\n",
+			verdict:  VerdictWrongAnswer,
+			position: &Position{Line: 1, Column: 1, Offset: 0, Token: 1},
+		},
+		{
+			name:    "lines in any order",
+			opts:    Options{Mode: ModeLines},
+			output:  "b\na \n",
+			answer:  "a\nb\n\n",
+			verdict: VerdictOK,
+		},
+		{
+			name:     "lines inner whitespace",
+			opts:     Options{Mode: ModeLines},
+			output:   "a  b\n",
+			answer:   "a b\n",
+			verdict:  VerdictPresentationError,
+			position: &Position{Line: 1, Column: 1, Offset: 0},
+		},
+		{
+			name:     "lines missing line",
+			opts:     Options{Mode: ModeLines},
+			output:   "a\n",
+			answer:   "a\nb\n",
+			verdict:  VerdictWrongAnswer,
+			position: &Position{Line: 2, Column: 1, Offset: 2},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			result, err := Check(strings.NewReader(test.output), strings.NewReader(test.answer), test.opts)
+			if err != nil {
+				t.Fatalf("Check returned error: %v", err)
+			}
+			if result.Verdict != test.verdict {
+				t.Fatalf("verdict = %s (%s), want %s", result.Verdict, result.Message, test.verdict)
+			}
+			switch {
+			case test.position == nil && result.Position != nil:
+				t.Errorf("position = %+v, want none", *result.Position)
+			case test.position != nil && result.Position == nil:
+				t.Errorf("position is missing, want %+v", *test.position)
+			case test.position != nil && *result.Position != *test.position:
+				t.Errorf("position = %+v, want %+v", *result.Position, *test.position)
+			}
+		})
+	}
+}
+
+func TestCheckUnknownMode(t *testing.T) {
+	if _, err := Check(strings.NewReader(""), strings.NewReader(""), Options{Mode: "unknown"}); err == nil {
+		t.Error("Check accepted unknown mode")
+	}
+}
+
+func TestTestlibResult(t *testing.T) {
+	tests := []struct {
+		name     string
+		exitCode int
+		comment  string
+		verdict  Verdict
+		points   *float64
+		message  string
+	}{
+		{name: "ok", exitCode: TestlibOK, comment: "ok 3 numbers\n", verdict: VerdictOK, message: "ok 3 numbers"},
+		{name: "wrong answer", exitCode: TestlibWA, comment: "wrong answer 1st numbers differ", verdict: VerdictWrongAnswer},
+		{name: "presentation error", exitCode: TestlibPE, verdict: VerdictPresentationError},
+		{name: "fail", exitCode: TestlibFail, comment: "answer is wrong", verdict: VerdictFail},
+		{name: "dirt", exitCode: TestlibDirt, comment: "extra tokens", verdict: VerdictPresentationError},
+		{name: "points", exitCode: TestlibPoints, comment: "points 5", verdict: VerdictPoints, points: floatPtr(5)},
+		{name: "points with message", exitCode: TestlibPoints, comment: " points 2.5 partial solution\n", verdict: VerdictPoints, points: floatPtr(2.5)},
+		{name: "points without prefix", exitCode: TestlibPoints, comment: "0.75", verdict: VerdictPoints, points: floatPtr(0.75)},
+		{name: "negative points", exitCode: TestlibPoints, comment: "points -1", verdict: VerdictPoints, points: floatPtr(-1)},
+		{name: "points without value", exitCode: TestlibPoints, comment: "points", verdict: VerdictFail},
+		{name: "empty points comment", exitCode: TestlibPoints, verdict: VerdictFail},
+		{name: "points not a number", exitCode: TestlibPoints, comment: "points many", verdict: VerdictFail},
+		{name: "points NaN", exitCode: TestlibPoints, comment: "points nan", verdict: VerdictFail},
+		{name: "points infinity", exitCode: TestlibPoints, comment: "points 1e999", verdict: VerdictFail},
+		{name: "unknown code", exitCode: 5, comment: "crashed", verdict: VerdictFail, message: "Checker exited with unexpected code 5: crashed"},
+		{name: "negative code", exitCode: -1, verdict: VerdictFail},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			result := TestlibResult(test.exitCode, test.comment)
+			if result.Verdict != test.verdict {
+				t.Fatalf("verdict = %s (%s), want %s", result.Verdict, result.Message, test.verdict)
+			}
+			switch {
+			case test.points == nil && result.Points != nil:
+				t.Errorf("points = %v, want none", *result.Points)
+			case test.points != nil && result.Points == nil:
+				t.Errorf("points are missing, want %v", *test.points)
+			case test.points != nil && *result.Points != *test.points:
+				t.Errorf("points = %v, want %v", *result.Points, *test.points)
+			}
+			if len(test.message) > 0 && result.Message != test.message {
+				t.Errorf("message = %q, want %q", result.Message, test.message)
+			}
+		})
+	}
+}
+
+func floatPtr(value float64) *float64 {
+	return &value
+}
@@ -0,0 +1,200 @@
+package checker
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// tokenComparator сравнивает слово из вывода участника <out> со словом из ответа <ans>
+// и возвращает вердикт и сообщение о расхождении.
+type tokenComparator func(out, ans string, opts Options) (Verdict, string)
+
+// checkExact сравнивает потоки побайтово. Если слова в потоках совпадают,
+// то расхождение считается ошибкой форматирования (PE).
+func checkExact(output, answer io.ReadSeeker) (*Result, error) {
+	out, ans := newReader(output), newReader(answer)
+	for {
+		pos := out.pos
+
+		a, aErr := ans.readByte()
+		if aErr != nil && aErr != io.EOF {
+			return nil, aErr
+		}
+		o, oErr := out.readByte()
+		if oErr != nil && oErr != io.EOF {
+			return nil, oErr
+		}
+
+		if aErr == io.EOF && oErr == io.EOF {
+			return accepted(), nil
+		}
+		if aErr == nil && oErr == nil && a == o {
+			continue
+		}
+
+		var message string
+		switch {
+		case oErr == io.EOF:
+			message = "Unexpected end of output"
+		case aErr == io.EOF:
+			message = "Extra data in output"
+		default:
+			message = fmt.Sprintf("Expected %q, found %q", a, o)
+		}
+
+		verdict, err := exactVerdict(output, answer)
+		if err != nil {
+			return nil, err
+		}
+		return mismatch(verdict, pos, message), nil
+	}
+}
+
+func exactVerdict(output, answer io.ReadSeeker) (Verdict, error) {
+	if _, err := output.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+	if _, err := answer.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+
+	result, err := checkTokens(output, answer, Options{Mode: ModeToken}, compareToken)
+	if err != nil {
+		return "", err
+	}
+	if result.Verdict == VerdictOK {
+		return VerdictPresentationError, nil
+	}
+	return VerdictWrongAnswer, nil
+}
+
+func checkTokens(output, answer io.Reader, opts Options, compare tokenComparator) (*Result, error) {
+	out, ans := newReader(output), newReader(answer)
+	for {
+		a, _, aErr := ans.token()
+		if aErr != nil && aErr != io.EOF {
+			return nil, aErr
+		}
+		o, pos, oErr := out.token()
+		if oErr != nil && oErr != io.EOF {
+			return nil, oErr
+		}
+
+		switch {
+		case aErr == io.EOF && oErr == io.EOF:
+			return accepted(), nil
+		case oErr == io.EOF:
+			return mismatch(VerdictWrongAnswer, pos, "Unexpected end of output, expected %q", shorten(a)), nil
+		case aErr == io.EOF:
+			return mismatch(VerdictWrongAnswer, pos, "Extra token %q in output", shorten(o)), nil
+		}
+
+		if verdict, message := compare(o, a, opts); verdict != VerdictOK {
+			return mismatch(verdict, pos, "%s", message), nil
+		}
+	}
+}
+
+func compareToken(out, ans string, _ Options) (Verdict, string) {
+	if out == ans {
+		return VerdictOK, ""
+	}
+	return VerdictWrongAnswer, fmt.Sprintf("Expected %q, found %q", shorten(ans), shorten(out))
+}
+
+// compareFloat сравнивает числа с погрешностью. Слова ответа, не являющиеся числами,
+// сравниваются точно; если на месте числа в выводе участника не число, то это PE.
+func compareFloat(out, ans string, opts Options) (Verdict, string) {
+	expected, ok := parseFloat(ans)
+	if !ok {
+		return compareToken(out, ans, opts)
+	}
+
+	found, ok := parseFloat(out)
+	if !ok {
+		return VerdictPresentationError, fmt.Sprintf("Expected a number, found %q", shorten(out))
+	}
+
+	if floatsEqual(found, expected, opts) {
+		return VerdictOK, ""
+	}
+	return VerdictWrongAnswer, fmt.Sprintf("Expected %s, found %s (difference %g)", ans, out, math.Abs(found-expected))
+}
+
+func parseFloat(s string) (float64, bool) {
+	value, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		if numErr, ok := err.(*strconv.NumError); !ok || numErr.Err != strconv.ErrRange {
+			return 0, false
+		}
+	}
+	return value, true
+}
+
+func floatsEqual(found, expected float64, opts Options) bool {
+	if math.IsNaN(found) || math.IsNaN(expected) {
+		return math.IsNaN(found) && math.IsNaN(expected)
+	}
+	if math.IsInf(found, 0) || math.IsInf(expected, 0) {
+		return found == expected
+	}
+
+	diff := math.Abs(found - expected)
+	return diff <= opts.AbsEpsilon || diff <= opts.RelEpsilon*math.Abs(expected)
+}
+
+// checkLines сравнивает множества строк без учета порядка и пробелов в конце строк.
+// Если строки совпадают после схлопывания пробелов внутри строк, то это PE.
+func checkLines(output, answer io.Reader) (*Result, error) {
+	outLines, err := readLines(output)
+	if err != nil {
+		return nil, err
+	}
+	ansLines, err := readLines(answer)
+	if err != nil {
+		return nil, err
+	}
+
+	result := compareLines(outLines, ansLines, trimLine)
+	if result.Verdict != VerdictOK && compareLines(outLines, ansLines, collapseLine).Verdict == VerdictOK {
+		result.Verdict = VerdictPresentationError
+	}
+	return result, nil
+}
+
+func compareLines(out, ans []string, normalize func(string) string) *Result {
+	count := make(map[string]int)
+	for _, line := range ans {
+		count[normalize(line)]++
+	}
+
+	var offset int64
+	for i, line := range out {
+		key := normalize(line)
+		if count[key] == 0 {
+			pos := Position{Line: i + 1, Column: 1, Offset: offset}
+			return mismatch(VerdictWrongAnswer, pos, "Unexpected line %q", shorten(line))
+		}
+		count[key]--
+		offset += int64(len(line)) + 1
+	}
+
+	for i, line := range ans {
+		if count[normalize(line)] > 0 {
+			pos := Position{Line: len(out) + 1, Column: 1, Offset: offset}
+			return mismatch(VerdictWrongAnswer, pos, "Line %d of answer (%q) is missing", i+1, shorten(line))
+		}
+	}
+	return accepted()
+}
+
+func trimLine(line string) string {
+	return strings.TrimRight(line, " \t\r")
+}
+
+func collapseLine(line string) string {
+	return strings.Join(strings.Fields(line), " ")
+}
@@ -0,0 +1,122 @@
+package checker
+
+import (
+	"bufio"
+	"io"
+	"strings"
+)
+
+// reader читает поток побайтово или по словам, отслеживая текущую позицию.
+type reader struct {
+	r      *bufio.Reader
+	pos    Position
+	tokens int
+}
+
+func newReader(r io.Reader) *reader {
+	return &reader{
+		r:   bufio.NewReader(r),
+		pos: Position{Line: 1, Column: 1},
+	}
+}
+
+func (r *reader) readByte() (byte, error) {
+	b, err := r.r.ReadByte()
+	if err != nil {
+		return 0, err
+	}
+	r.advance(b)
+	return b, nil
+}
+
+func (r *reader) advance(b byte) {
+	r.pos.Offset++
+	if b == '\n' {
+		r.pos.Line++
+		r.pos.Column = 1
+	} else {
+		r.pos.Column++
+	}
+}
+
+// token возвращает следующее слово и позицию его начала.
+// Если слов больше нет, возвращается io.EOF и позиция конца потока.
+func (r *reader) token() (string, Position, error) {
+	for {
+		b, err := r.r.ReadByte()
+		if err != nil {
+			pos := r.pos
+			pos.Token = r.tokens + 1
+			return "", pos, err
+		}
+		if !isSpace(b) {
+			r.r.UnreadByte()
+			break
+		}
+		r.advance(b)
+	}
+
+	r.tokens++
+	start := r.pos
+	start.Token = r.tokens
+
+	var token []byte
+	for {
+		b, err := r.r.ReadByte()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return "", start, err
+		}
+		if isSpace(b) {
+			r.r.UnreadByte()
+			break
+		}
+		r.advance(b)
+		token = append(token, b)
+	}
+	return string(token), start, nil
+}
+
+// readLines читает строки потока без символов перевода строки.
+// Пустые строки в конце потока не учитываются.
+func readLines(r io.Reader) ([]string, error) {
+	br := bufio.NewReader(r)
+
+	var lines []string
+	for {
+		line, err := br.ReadString('\n')
+		if len(line) > 0 || err == nil {
+			lines = append(lines, strings.TrimSuffix(line, "\n"))
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for len(lines) > 0 && len(trimLine(lines[len(lines)-1])) == 0 {
+		lines = lines[:len(lines)-1]
+	}
+	return lines, nil
+}
+
+func isSpace(b byte) bool {
+	switch b {
+	case ' ', '\t', '\n', '\r', '\v', '\f':
+		return true
+	}
+	return false
+}
+
+// shorten обрезает длинные слова и строки для сообщений о расхождениях.
+func shorten(s string) string {
+	const max = 64
+	if len(s) <= max {
+		return s
+	}
+	return s[:max] + "..."
+}
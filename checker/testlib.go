@@ -0,0 +1,62 @@
+package checker
+
+import (
+	"fmt"
+	"math"
+	"strings"
+)
+
+// Коды выхода чекеров, написанных с использованием testlib.
+const (
+	TestlibOK     = 0
+	TestlibWA     = 1
+	TestlibPE     = 2
+	TestlibFail   = 3
+	TestlibDirt   = 4 // лишние данные в конце вывода участника
+	TestlibPoints = 7
+)
+
+// TestlibResult переводит код выхода testlib чекера <exitCode> и его комментарий <comment>
+// (вывод в stderr) в результат проверки. При коде "points" комментарий имеет вид
+// "points <баллы> [<сообщение>]".
+func TestlibResult(exitCode int, comment string) *Result {
+	comment = strings.TrimSpace(comment)
+
+	result := &Result{Message: comment}
+	switch exitCode {
+	case TestlibOK:
+		result.Verdict = VerdictOK
+	case TestlibWA:
+		result.Verdict = VerdictWrongAnswer
+	case TestlibPE, TestlibDirt:
+		result.Verdict = VerdictPresentationError
+	case TestlibFail:
+		result.Verdict = VerdictFail
+	case TestlibPoints:
+		points, ok := parsePoints(comment)
+		if !ok {
+			result.Verdict = VerdictFail
+			result.Message = fmt.Sprintf("Unable to parse points from checker comment: %q", shorten(comment))
+			break
+		}
+		result.Verdict = VerdictPoints
+		result.Points = &points
+	default:
+		result.Verdict = VerdictFail
+		result.Message = fmt.Sprintf("Checker exited with unexpected code %d: %s", exitCode, comment)
+	}
+	return result
+}
+
+// parsePoints возвращает баллы из комментария чекера. Бесконечность и NaN баллами не считаются.
+func parsePoints(comment string) (float64, bool) {
+	fields := strings.Fields(strings.TrimPrefix(comment, "points "))
+	if len(fields) == 0 {
+		return 0, false
+	}
+	points, ok := parseFloat(fields[0])
+	if !ok || math.IsNaN(points) || math.IsInf(points, 0) {
+		return 0, false
+	}
+	return points, true
+}
@@ -14,4 +14,5 @@ require (
 	github.com/x-cray/logrus-prefixed-formatter v0.5.2
 	golang.org/x/crypto v0.0.0-20190418165655-df01cb2cc480 // indirect
 	golang.org/x/sys v0.0.0-20190419153524-e8e3143a4f4a
+	gopkg.in/yaml.v2 v2.4.0
 )
@@ -0,0 +1,101 @@
+package instance
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"sync/atomic"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/solovev/orange-app-runner/system"
+)
+
+const cgroupPeriod = 100000
+
+var cgroupControllers = []string{"memory", "pids", "cpu"}
+
+// cgroupCounter различает cgroup одновременных запусков в одном процессе.
+var cgroupCounter uint64
+
+// CgroupStats содержит показатели, полученные из cgroup после завершения tracee.
+type CgroupStats struct {
+	OOMKills   uint64  `json:"oom_kills"`
+	CPUTime    float64 `json:"cpu_time"`
+	MemoryPeak int64   `json:"memory_peak,omitempty"`
+}
+
+// CreateCgroup создает отдельную cgroup для запуска и устанавливает в ней ограничения из <cfg>.
+func CreateCgroup(cfg *Config) (*system.Cgroup, error) {
+	name := fmt.Sprintf("oar-%d-%d", os.Getpid(), atomic.AddUint64(&cgroupCounter, 1))
+	cg, err := system.CreateCgroup(cfg.CgroupPath, name, cgroupControllers)
+	if err != nil {
+		return nil, err
+	}
+	log.Debugf("Cgroup created: %s\n", cg.Path)
+
+	settings := make(map[string]string)
+	if cfg.MemoryLimit > 0 {
+		settings["memory.max"] = strconv.FormatInt(cfg.MemoryLimit*1024, 10)
+		settings["memory.swap.max"] = "0"
+	}
+	if cfg.ProcessLimit > 0 {
+		settings["pids.max"] = strconv.FormatInt(cfg.ProcessLimit, 10)
+	}
+	if cfg.CPUQuota > 0 {
+		quota := int64(cfg.CPUQuota * cgroupPeriod)
+		settings["cpu.max"] = fmt.Sprintf("%d %d", quota, cgroupPeriod)
+	}
+
+	for file, value := range settings {
+		if err := cg.Set(file, value); err != nil {
+			cg.Remove()
+			return nil, fmt.Errorf("Unable to set \"%s\" to \"%s\": %v", file, value, err)
+		}
+		log.Debugf("Cgroup \"%s\" is set to %s\n", file, value)
+	}
+	return cg, nil
+}
+
+// ApplyCgroupStats уточняет вердикт отчета <report> по данным "memory.events" и "cpu.stat"
+// и возвращает код выхода, соответствующий итоговому вердикту.
+func ApplyCgroupStats(cg *system.Cgroup, cfg *Config, report *Report, exitCode int) (int, error) {
+	events, err := cg.Stat("memory.events")
+	if err != nil {
+		return exitCode, err
+	}
+	cpu, err := cg.Stat("cpu.stat")
+	if err != nil {
+		return exitCode, err
+	}
+
+	stats := &CgroupStats{
+		OOMKills: events["oom_kill"],
+		CPUTime:  float64(cpu["usage_usec"]) / 1000.0,
+	}
+	if peak, err := cg.Value("memory.peak"); err == nil {
+		stats.MemoryPeak = int64(peak / 1024)
+	}
+	log.Debugf("Cgroup stats: %+v\n", stats)
+
+	var tErr *TracerError
+	switch {
+	case stats.OOMKills > 0:
+		tErr = ErrMemoryLimitExceeded
+	case cfg.CPUTimeLimit > 0 && stats.CPUTime >= cfg.CPUTimeLimit:
+		tErr = ErrCPUTimeLimitExceeded
+	}
+
+	if report != nil {
+		report.Cgroup = stats
+		if tErr != nil && report.Verdict != tErr.Verdict {
+			report.Verdict = tErr.Verdict
+			report.Tag = "cgroup"
+			report.Error = tErr.Parent.Error()
+		}
+	}
+
+	if tErr != nil {
+		return tErr.Code, nil
+	}
+	return exitCode, nil
+}
@@ -18,8 +18,8 @@ func setAffinity(pid int, cfg *Config) error {
 	return nil
 }
 
-func wait(pid int) (cpid int, status syscall.WaitStatus, err error) {
-	cpid, err = syscall.Wait4(pid, &status, syscall.WALL, nil)
+func wait(pid int, usage *syscall.Rusage) (cpid int, status syscall.WaitStatus, err error) {
+	cpid, err = syscall.Wait4(pid, &status, syscall.WALL, usage)
 	if err != nil {
 		return 0, 0, err
 	}
@@ -1,30 +1,96 @@
 package instance
 
 import (
+	"fmt"
 	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
 
+	"github.com/jessevdk/go-flags"
 	"github.com/solovev/orange-app-runner/util"
 )
 
 type Config struct {
 	Debug bool `long:"debug" description:"Enable debug output"`
 
-	RootFS       string `long:"rootfs" description:"Set path to the root filesystem to use"`
-	NetSetGoPath string `long:"nsgpath" description:"Set path to the netsetgo binary"`
+	RootFS       string   `long:"rootfs" description:"Set path to the root filesystem to use"`
+	Binds        []string `long:"bind" description:"Bind mount a host path into the root filesystem (src:dst[:ro], \"ro\" makes it recursively read-only)"`
+	TmpFS        []string `long:"tmpfs" description:"Mount tmpfs of the specified size into the root filesystem (dst:size, e.g. /tmp:64m)"`
+	NetSetGoPath string   `long:"nsgpath" description:"Set path to the netsetgo binary"`
 
 	Env               []string `long:"env" description:"Add environment variable (by default, system's environment variables is completely ignored)"`
 	Affinity          []int    `short:"a" long:"affinity" description:"Add an index of CPU to the list of cores that the process can use. If not specified, child process will be use all available cores. Specify \"-1\" to use single most unload CPU core"`
 	WorkingDir        string   `short:"d" long:"dir" description:"Set path to working directory for process"`
 	PropagateExitCode bool     `short:"x" long:"exit" description:"Enable exit code propagation (return exit code from tracee application)"`
+	InputFile         string   `long:"stdin" description:"Redirect standard input stream of tracee from the specified file (\"-\" to inherit tracer's stream)" default:"/dev/null"`
+	OutputFile        string   `long:"stdout" description:"Redirect standard output stream of tracee to the specified file"`
+	ErrorFile         string   `long:"stderr" description:"Redirect standard error stream of tracee to the specified file"`
+	ReportPath        string   `long:"report" description:"Write JSON report (verdict, time and memory usage, exit status) of the tracee run to the specified file"`
 
 	CPUTimeLimit  float64 `short:"c" long:"cput-limit" description:"Terminate tracee if its process has been scheduled in user and kernel mode more than specified time in milliseconds" optional:"yes" optional-value:"-1" default:"-1"`
 	RealTimeLimit int64   `short:"t" long:"rt-limit" description:"Terminate tracee after specified milliseconds" optional:"yes" optional-value:"-1" default:"-1"`
 	MemoryLimit   int64   `short:"m" long:"mem-limit" description:"Terminate tracee if the memory consumption exceeds the specified number of kilobytes" optional:"yes" optional-value:"-1" default:"-1"`
+	IdleLimit     int64   `long:"idle-limit" description:"Terminate tracee if its CPU load stays below --required-load for more than specified milliseconds (e.g. waiting for input)" optional:"yes" optional-value:"-1" default:"-1"`
+	RequiredLoad  float64 `long:"required-load" description:"Set minimal CPU load (fraction of one core) at which tracee is not considered idle" default:"0.05"`
+	OutputLimit   int64   `long:"output-limit" description:"Terminate tracee if it writes more than the specified number of kilobytes to stdout or stderr (each stream is counted separately), to any single file or to all files under --dir in total" optional:"yes" optional-value:"-1" default:"-1"`
+
+	MemoryAccounting string `long:"mem-accounting" description:"Set memory compared with --mem-limit: peak resident memory (hwm), current resident memory (rss) or peak address space (vm) summed over tracee processes" choice:"hwm" choice:"rss" choice:"vm" default:"hwm"`
+
+	StatsPath     string `long:"stats" description:"Write resource usage samples of tracee and its child processes (CPU time, RSS, VmHWM, threads, processes, I/O bytes) to the specified file"`
+	StatsInterval int64  `long:"stats-interval" description:"Set sampling interval of --stats in milliseconds" default:"100"`
+	StatsFormat   string `long:"stats-format" description:"Set format of --stats file" choice:"csv" choice:"jsonl" default:"csv"`
+
+	SyscallLogPath string `long:"syscall-log" description:"Write every syscall of tracee and its child processes (pid, arguments, result, time) and a summary table of counts and time per syscall to the specified file"`
+
+	CgroupPath   string  `long:"cgroup" description:"Set path to the delegated cgroup v2 directory, each run will be placed in its own leaf cgroup inside it"`
+	ProcessLimit int64   `long:"pids-limit" description:"Set maximum number of processes and threads in the run's cgroup (pids.max)" optional:"yes" optional-value:"-1" default:"-1"`
+	CPUQuota     float64 `long:"cpu-quota" description:"Set CPU bandwidth of the run's cgroup in cores, e.g. 0.5 or 2 (cpu.max)" optional:"yes" optional-value:"-1" default:"-1"`
+
+	KillSignal string `long:"kill-signal" description:"Send the specified signal (name or number, e.g. TERM) to tracee processes when a limit is exceeded, SIGKILL follows after --kill-grace" default:"KILL"`
+	KillGrace  int64  `long:"kill-grace" description:"Set time in milliseconds between --kill-signal and SIGKILL of all tracee processes" default:"0"`
 
 	AllowCreateProcesses bool `long:"allow-create-processes" description:"Allow to spawn child processes by tracee process"`
 	AllowMultiThreading  bool `long:"allow-multithreading" description:"Allow tracee process to clone himself for new thread creation"`
+	AllowSignalHandlers  bool `long:"allow-signal-handlers" description:"Deliver SIGSEGV, SIGBUS, SIGFPE, SIGILL and SIGABRT to tracee if it handles them itself instead of terminating it with runtime error"`
+	AllowExec            bool `long:"allow-exec" description:"Allow tracee process to replace itself with another program (execve)"`
 	MaxPtraceIterations  int  `long:"max-ptrace-iterations" description:"Set limit of number of ptrace loop iterations (debug purposes)" optional:"yes" optional-value:"-1" default:"-1"`
+
+	SeccompAllow  []string `long:"seccomp-allow" description:"Add syscall to the seccomp allow list, all syscalls outside of the list will be handled by --seccomp-action"`
+	SeccompDeny   []string `long:"seccomp-deny" description:"Add syscall to the seccomp deny list, syscalls from the list will be handled by --seccomp-action"`
+	SeccompAction string   `long:"seccomp-action" description:"Set action for syscalls rejected by seccomp filter" choice:"KILL" choice:"ERRNO" choice:"TRACE" default:"TRACE"`
+	PolicyPath    string   `long:"policy" description:"Set path to the YAML or JSON syscall policy file or name of the built-in policy (cpp, python, java, go)"`
+
+	FSRead          []string `long:"fs-read" description:"Allow tracee to read files under the specified path, any of --fs-* options enables the filesystem policy that denies access to other paths"`
+	FSWrite         []string `long:"fs-write" description:"Allow tracee to read and modify existing files under the specified path"`
+	FSCreate        []string `long:"fs-create" description:"Allow tracee to read, modify, create, rename and remove files and directories under the specified path"`
+	FSExec          []string `long:"fs-exec" description:"Allow tracee to execute programs under the specified path"`
+	RequireLandlock bool     `long:"require-landlock" description:"Refuse to run with --fs-* options if the kernel does not support Landlock instead of checking paths with ptrace only"`
+
+	// Файлы, переданные tracer'у родительским процессом (не являются параметрами командной строки).
+	CgroupProcs *os.File `no-flag:"yes" json:"-"`
+	// Стандартные потоки tracee, открытые OpenStdio.
+	Stdio []*os.File `no-flag:"yes" json:"-"`
+	// Файл "--stats", открытый OpenStats.
+	StatsFile *os.File `no-flag:"yes" json:"-"`
+	// Файл "--syscall-log", открытый OpenSyscallLog.
+	SyscallLogFile *os.File `no-flag:"yes" json:"-"`
+
+	// Политика, загруженная из "--policy".
+	Policy *Policy `no-flag:"yes" json:"-"`
+	// LearnPolicy - собрать политику по работе tracee ("oar learn"), она возвращается в отчете.
+	LearnPolicy bool `no-flag:"yes" json:"-"`
+}
+
+// DefaultConfig возвращает параметры запуска со значениями по умолчанию из параметров командной строки
+// (ограничения отключены). Используется при запуске из Go кода вместо нулевого значения Config.
+func DefaultConfig() Config {
+	var cfg Config
+	if _, err := flags.NewParser(&cfg, flags.None).ParseArgs(nil); err != nil {
+		panic(err)
+	}
+	return cfg
 }
 
 func (cfg *Config) CheckRootFS() error {
@@ -56,3 +122,32 @@ func (cfg *Config) CheckNetSetGoPath() error {
 
 	return nil
 }
+
+// signalNames - сигналы, которые можно указать в "--kill-signal" по имени.
+var signalNames = map[string]syscall.Signal{
+	"HUP":  syscall.SIGHUP,
+	"INT":  syscall.SIGINT,
+	"QUIT": syscall.SIGQUIT,
+	"ABRT": syscall.SIGABRT,
+	"KILL": syscall.SIGKILL,
+	"USR1": syscall.SIGUSR1,
+	"USR2": syscall.SIGUSR2,
+	"PIPE": syscall.SIGPIPE,
+	"ALRM": syscall.SIGALRM,
+	"TERM": syscall.SIGTERM,
+	"XCPU": syscall.SIGXCPU,
+}
+
+// parseSignal разбирает имя ("TERM", "SIGTERM") или номер сигнала. Пустое значение - SIGKILL.
+func parseSignal(value string) (syscall.Signal, error) {
+	if len(value) == 0 {
+		return syscall.SIGKILL, nil
+	}
+	if number, err := strconv.Atoi(value); err == nil && number > 0 && number < 65 {
+		return syscall.Signal(number), nil
+	}
+	if sig, ok := signalNames[strings.TrimPrefix(strings.ToUpper(value), "SIG")]; ok {
+		return sig, nil
+	}
+	return 0, fmt.Errorf("Unknown signal \"%s\"", value)
+}
@@ -0,0 +1,113 @@
+package instance
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/solovev/orange-app-runner/system"
+)
+
+// cpuSliceMin - наименьшее время, на которое взводится таймер процесса.
+const cpuSliceMin = time.Millisecond
+
+// cpuLimiter взводит таймеры на часах процессорного времени живых процессов дерева tracee.
+// Каждый таймер срабатывает, когда процесс израсходует равную долю оставшегося времени,
+// поэтому до срабатывания любого из них сумма не может превысить ограничение.
+type cpuLimiter struct {
+	limit     float64
+	processes *processTable
+	timers    map[int]*system.CPUTimer
+}
+
+// update пересчитывает время процессора дерева и взводит таймеры заново. Возвращает
+// ErrCPUTimeLimitExceeded, если ограничение уже достигнуто.
+func (l *cpuLimiter) update() error {
+	cpu, pids := l.processes.cpuTime()
+	remaining := l.limit - cpu
+	if remaining <= 0 {
+		log.Infof("CPU time limit is reached: %.3fms / %vms\n", cpu, l.limit)
+		return ErrCPUTimeLimitExceeded
+	}
+
+	slice := time.Duration(remaining * float64(time.Millisecond) / float64(len(pids)+1))
+	if slice < cpuSliceMin {
+		slice = cpuSliceMin
+	}
+
+	live := make(map[int]bool, len(pids))
+	for _, pid := range pids {
+		live[pid] = true
+
+		timer, ok := l.timers[pid]
+		if !ok {
+			var err error
+			if timer, err = system.NewCPUTimer(pid, syscall.SIGXCPU); err != nil {
+				log.Debugf("Unable to create CPU timer for process %d: %v\n", pid, err)
+				continue
+			}
+			l.timers[pid] = timer
+		}
+
+		own, err := system.GetProcessCPUTime(pid)
+		if err != nil {
+			continue
+		}
+		if err = timer.Set(own + slice); err != nil {
+			log.Debugf("Unable to set CPU timer for process %d: %v\n", pid, err)
+		}
+	}
+
+	for pid, timer := range l.timers {
+		if !live[pid] {
+			timer.Delete()
+			delete(l.timers, pid)
+		}
+	}
+	return nil
+}
+
+func (l *cpuLimiter) close() {
+	for pid, timer := range l.timers {
+		timer.Delete()
+		delete(l.timers, pid)
+	}
+}
+
+// startLimitingCPU завершает tracee, как только суммарное время процессора его процессов
+// достигнет cfg.CPUTimeLimit. Сработавший таймер присылает tracer'у SIGXCPU; таймеры
+// также взводятся заново при появлении новых процессов.
+func startLimitingCPU(tracee *traceeInstance, cfg *Config) {
+	tracee.wg.Add(1)
+	defer tracee.wg.Done()
+
+	log.Debugln("Goroutine \"startLimitingCPU\" started")
+	defer log.Debugln("Goroutine \"startLimitingCPU\" terminated")
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGXCPU)
+	defer signal.Stop(signals)
+
+	limiter := &cpuLimiter{
+		limit:     cfg.CPUTimeLimit,
+		processes: tracee.processes,
+		timers:    make(map[int]*system.CPUTimer),
+	}
+	defer limiter.close()
+
+	for {
+		if err := limiter.update(); err != nil {
+			tracee.kill(createTracerError("startLimitingCPU", err))
+			return
+		}
+
+		select {
+		case <-tracee.stopc:
+			return
+		case <-signals:
+		case <-tracee.processes.spawnc:
+		}
+	}
+}
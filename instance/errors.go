@@ -11,21 +11,42 @@ func (e *TraceeExitError) Error() string {
 }
 
 type TracerError struct {
-	Tag    string
-	Code   int
-	Parent error
+	Tag     string
+	Code    int
+	Verdict Verdict
+	Syscall string
+	Stream  string
+	Parent  error
+	// Runtime - классификация ошибки выполнения (вердикт RE).
+	Runtime *RuntimeError
+	// Path и Access - путь и вид доступа, запрещенные политикой файловой системы.
+	Path   string
+	Access string
 }
 
 func (e TracerError) Error() string {
 	return fmt.Sprintf("tracer error (code: %d): \"%v\"", e.Code, e.Parent)
 }
 
-func defineTracerError(code int, parent error) *TracerError {
-	return &TracerError{Code: code, Parent: parent}
+func defineTracerError(code int, verdict Verdict, parent error) *TracerError {
+	return &TracerError{Code: code, Verdict: verdict, Parent: parent}
 }
 
+// createTracerError оборачивает ошибку <parent> тегом <tag>. Если <parent> уже
+// является TracerError, то ее код и вердикт сохраняются.
 func createTracerError(tag string, parent error) *TracerError {
-	return &TracerError{Code: 1, Tag: tag, Parent: parent}
+	if e, ok := parent.(*TracerError); ok {
+		return &TracerError{Code: e.Code, Verdict: e.Verdict, Syscall: e.Syscall, Stream: e.Stream, Tag: tag, Parent: e.Parent, Runtime: e.Runtime, Path: e.Path, Access: e.Access}
+	}
+	return &TracerError{Code: 1, Verdict: VerdictInternalError, Tag: tag, Parent: parent}
+}
+
+func createRuntimeError(tag string, parent error) *TracerError {
+	return &TracerError{Code: 1, Verdict: VerdictRuntimeError, Tag: tag, Parent: parent}
+}
+
+func createViolationError(tag string, parent error) *TracerError {
+	return &TracerError{Code: 1, Verdict: VerdictSecurityViolation, Tag: tag, Parent: parent}
 }
 
 // = = = = = = = = = = = = = = = = = = = = = = = =
@@ -0,0 +1,274 @@
+package instance
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/solovev/orange-app-runner/system"
+	"golang.org/x/sys/unix"
+)
+
+// Виды доступа к файловой системе, проверяемые политикой "--fs-*".
+const (
+	AccessRead   = "read"
+	AccessWrite  = "write"
+	AccessCreate = "create"
+	AccessExec   = "exec"
+)
+
+// maxPathLength - наибольшая длина пути, читаемого из памяти tracee (PATH_MAX).
+const maxPathLength = 4096
+
+// fsAccessLists - списки "--fs-*", разрешающие каждый вид доступа: запись разрешает и чтение,
+// создание - запись и чтение.
+var fsAccessLists = map[string][]string{
+	AccessRead:   {AccessRead, AccessWrite, AccessCreate},
+	AccessWrite:  {AccessWrite, AccessCreate},
+	AccessCreate: {AccessCreate},
+	AccessExec:   {AccessExec},
+}
+
+// fsPathArg - путь, передаваемый системному вызову: индекс аргумента с дескриптором каталога
+// (-1 - путь относительно текущего каталога), индекс аргумента с путем и вид доступа.
+// Для <follow> раскрываются все символические ссылки пути, иначе - все, кроме последней.
+type fsPathArg struct {
+	dirfd  int
+	path   int
+	access string
+	follow bool
+}
+
+// fsSyscalls описывает системные вызовы, проверяемые политикой, кроме open, openat, openat2
+// и creat, вид доступа которых зависит от флагов.
+var fsSyscalls = map[string][]fsPathArg{
+	"execve":    {{-1, 0, AccessExec, true}},
+	"execveat":  {{0, 1, AccessExec, true}},
+	"truncate":  {{-1, 0, AccessWrite, true}},
+	"chmod":     {{-1, 0, AccessWrite, true}},
+	"fchmodat":  {{0, 1, AccessWrite, true}},
+	"chown":     {{-1, 0, AccessWrite, true}},
+	"lchown":    {{-1, 0, AccessWrite, false}},
+	"fchownat":  {{0, 1, AccessWrite, true}},
+	"utimensat": {{0, 1, AccessWrite, true}},
+	"mkdir":     {{-1, 0, AccessCreate, false}},
+	"mkdirat":   {{0, 1, AccessCreate, false}},
+	"mknod":     {{-1, 0, AccessCreate, false}},
+	"mknodat":   {{0, 1, AccessCreate, false}},
+	"rmdir":     {{-1, 0, AccessCreate, false}},
+	"unlink":    {{-1, 0, AccessCreate, false}},
+	"unlinkat":  {{0, 1, AccessCreate, false}},
+	"rename":    {{-1, 0, AccessCreate, false}, {-1, 1, AccessCreate, false}},
+	"renameat":  {{0, 1, AccessCreate, false}, {2, 3, AccessCreate, false}},
+	"renameat2": {{0, 1, AccessCreate, false}, {2, 3, AccessCreate, false}},
+	"link":      {{-1, 0, AccessCreate, false}, {-1, 1, AccessCreate, false}},
+	"linkat":    {{0, 1, AccessCreate, false}, {2, 3, AccessCreate, false}},
+	"symlink":   {{-1, 1, AccessCreate, false}},
+	"symlinkat": {{1, 2, AccessCreate, false}},
+}
+
+// fsViolation - запрещенный политикой доступ к файлу.
+type fsViolation struct {
+	syscall string
+	path    string
+	access  string
+}
+
+func (v *fsViolation) Error() string {
+	return fmt.Sprintf("Access \"%s\" to \"%s\" is denied by filesystem policy (%s)", v.access, v.path, v.syscall)
+}
+
+// fsPolicy - пути, разрешенные для каждого вида доступа: абсолютные, с раскрытыми символическими ссылками.
+type fsPolicy struct {
+	paths map[string][]string
+}
+
+// filesystemPolicy возвращает политику доступа к файловой системе из параметров "--fs-*" или nil,
+// если ни один из них не указан. Относительные пути отсчитываются от рабочего каталога tracee,
+// который доступен для создания файлов. Должна вызываться tracer'ом после pivot_root.
+func (cfg *Config) filesystemPolicy() (*fsPolicy, error) {
+	lists := map[string][]string{
+		AccessRead:   cfg.FSRead,
+		AccessWrite:  cfg.FSWrite,
+		AccessCreate: cfg.FSCreate,
+		AccessExec:   cfg.FSExec,
+	}
+	if len(cfg.FSRead)+len(cfg.FSWrite)+len(cfg.FSCreate)+len(cfg.FSExec) == 0 {
+		return nil, nil
+	}
+
+	// Пустой "--dir" - текущий каталог tracer'а. Он доступен для создания файлов только если
+	// указан явно и не является корнем, иначе любой "--fs-*" открыл бы всю файловую систему.
+	workingDir, err := filepath.Abs(cfg.WorkingDir)
+	if err != nil {
+		return nil, err
+	}
+	if len(cfg.WorkingDir) > 0 && workingDir != "/" {
+		lists[AccessCreate] = append([]string{workingDir}, lists[AccessCreate]...)
+	}
+
+	policy := &fsPolicy{paths: make(map[string][]string)}
+	for access, list := range lists {
+		for _, path := range list {
+			if !filepath.IsAbs(path) {
+				path = filepath.Join(workingDir, path)
+			}
+			policy.paths[access] = append(policy.paths[access], resolveSymlinks(filepath.Clean(path)))
+		}
+	}
+	return policy, nil
+}
+
+// allowed возвращает true, если доступ <access> к пути <path> разрешен.
+func (p *fsPolicy) allowed(access, path string) bool {
+	for _, list := range fsAccessLists[access] {
+		for _, prefix := range p.paths[list] {
+			if path == prefix || strings.HasPrefix(path, prefix+"/") || prefix == "/" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// check проверяет пути системного вызова <state>, на входе в который остановлен процесс <pid>
+// (<procfsPid> - номер процесса в /proc). Возвращает nil, если вызов не обращается к файлам
+// или доступ разрешен.
+func (p *fsPolicy) check(pid, procfsPid int, state *system.SyscallState) (*fsViolation, error) {
+	name := system.SyscallName(state.Nr)
+
+	args, ok := fsSyscalls[name]
+	if !ok {
+		var err error
+		if args, err = openPathArgs(pid, name, state); err != nil || args == nil {
+			return nil, err
+		}
+	}
+
+	for _, arg := range args {
+		dirfd := int32(unix.AT_FDCWD)
+		if arg.dirfd >= 0 {
+			dirfd = int32(state.Args[arg.dirfd])
+		}
+
+		addr := state.Args[arg.path]
+		if addr == 0 {
+			// utimensat(fd, NULL, ...) изменяет файл по дескриптору.
+			continue
+		}
+		// Путь, который не удалось прочитать или разрешить, запрещается: иначе процесс мог бы
+		// обойти политику, подменив память или дескриптор между проверкой и вызовом.
+		path, _, err := system.ReadString(pid, addr, maxPathLength)
+		if err != nil {
+			return &fsViolation{syscall: name, path: fmt.Sprintf("<unreadable path at 0x%x>", addr), access: arg.access}, nil
+		}
+		resolved, err := resolvePath(procfsPid, dirfd, path, arg.follow)
+		if err != nil {
+			return &fsViolation{syscall: name, path: path, access: arg.access}, nil
+		}
+
+		access := arg.access
+		if access == AccessCreate && len(args) == 1 && isOpenSyscall(name) && fileExists(resolved) {
+			// O_CREAT для существующего файла - запись.
+			access = AccessWrite
+		}
+		if !p.allowed(access, resolved) {
+			return &fsViolation{syscall: name, path: resolved, access: access}, nil
+		}
+	}
+	return nil, nil
+}
+
+func isOpenSyscall(name string) bool {
+	return name == "open" || name == "openat" || name == "openat2" || name == "creat"
+}
+
+// openPathArgs определяет вид доступа вызовов open, openat, openat2 и creat по их флагам.
+// Для остальных вызовов возвращает nil.
+func openPathArgs(pid int, name string, state *system.SyscallState) ([]fsPathArg, error) {
+	var flags uint64
+	var arg fsPathArg
+	switch name {
+	case "open":
+		flags, arg = state.Args[1], fsPathArg{dirfd: -1, path: 0}
+	case "openat":
+		flags, arg = state.Args[2], fsPathArg{dirfd: 0, path: 1}
+	case "openat2":
+		// Флаги - первое поле struct open_how.
+		var err error
+		if flags, err = system.ReadUint64(pid, state.Args[2]); err != nil {
+			return nil, err
+		}
+		arg = fsPathArg{dirfd: 0, path: 1}
+	case "creat":
+		flags, arg = unix.O_CREAT|unix.O_WRONLY|unix.O_TRUNC, fsPathArg{dirfd: -1, path: 0}
+	default:
+		return nil, nil
+	}
+
+	arg.follow = flags&unix.O_NOFOLLOW == 0
+	switch {
+	case flags&unix.O_TMPFILE == unix.O_TMPFILE || flags&unix.O_CREAT != 0:
+		// Если файл уже существует, check заменяет создание записью.
+		arg.access = AccessCreate
+	case flags&unix.O_ACCMODE != unix.O_RDONLY || flags&unix.O_TRUNC != 0:
+		arg.access = AccessWrite
+	default:
+		arg.access = AccessRead
+	}
+	return []fsPathArg{arg}, nil
+}
+
+// resolvePath возвращает абсолютный путь <path>, заданный относительно каталога <dirfd>
+// процесса <procfsPid> (или его текущего каталога для AT_FDCWD), с раскрытыми символическими
+// ссылками (кроме последней, если не указан <follow>).
+func resolvePath(procfsPid int, dirfd int32, path string, follow bool) (string, error) {
+	if !filepath.IsAbs(path) {
+		var base string
+		var err error
+		if dirfd == unix.AT_FDCWD {
+			base, err = system.GetProcessCwd(procfsPid)
+		} else {
+			base, err = system.GetProcessFdPath(procfsPid, int(dirfd))
+		}
+		if err != nil {
+			return "", err
+		}
+		path = filepath.Join(base, path)
+	}
+	path = filepath.Clean(path)
+
+	// "/proc/self" указывал бы на tracer.
+	self := "/proc/" + strconv.Itoa(procfsPid)
+	for _, link := range []string{"/proc/self", "/proc/thread-self"} {
+		if path == link || strings.HasPrefix(path, link+"/") {
+			path = self + strings.TrimPrefix(path, link)
+		}
+	}
+
+	if follow {
+		return resolveSymlinks(path), nil
+	}
+	dir, base := filepath.Split(path)
+	return filepath.Join(resolveSymlinks(filepath.Clean(dir)), base), nil
+}
+
+// resolveSymlinks раскрывает символические ссылки в существующей части пути <path>,
+// несуществующая часть добавляется к результату без изменений.
+func resolveSymlinks(path string) string {
+	if resolved, err := filepath.EvalSymlinks(path); err == nil {
+		return resolved
+	}
+	dir := filepath.Dir(path)
+	if dir == path {
+		return path
+	}
+	return filepath.Join(resolveSymlinks(dir), filepath.Base(path))
+}
+
+func fileExists(path string) bool {
+	_, err := os.Lstat(path)
+	return err == nil
+}
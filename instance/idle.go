@@ -0,0 +1,52 @@
+package instance
+
+import (
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// idleMeter считает время простоя tracee: суммарную длительность подряд идущих интервалов
+// между проверками, в которых загрузка процессора (доля одного ядра) была ниже требуемой.
+type idleMeter struct {
+	limit    time.Duration
+	required float64
+
+	cpu     float64
+	checked time.Time
+	idle    time.Duration
+}
+
+func newIdleMeter(cfg *Config) *idleMeter {
+	return &idleMeter{
+		limit:    time.Duration(cfg.IdleLimit) * time.Millisecond,
+		required: cfg.RequiredLoad,
+		checked:  time.Now(),
+	}
+}
+
+// update учитывает время процессора <cpu> (в миллисекундах), использованное деревом tracee к текущему
+// моменту, и возвращает ErrIdleLimitExceeded, если tracee простаивает дольше ограничения.
+func (m *idleMeter) update(cpu float64) error {
+	now := time.Now()
+	elapsed := now.Sub(m.checked)
+	if elapsed <= 0 {
+		return nil
+	}
+
+	used := time.Duration((cpu - m.cpu) * float64(time.Millisecond))
+	load := float64(used) / float64(elapsed)
+	m.cpu, m.checked = cpu, now
+
+	if load < m.required {
+		m.idle += elapsed
+	} else {
+		m.idle = 0
+	}
+	log.Debugf("CPU load: %.3f (required: %.3f), idle for %v of %v\n", load, m.required, m.idle, m.limit)
+
+	if m.idle >= m.limit {
+		return ErrIdleLimitExceeded
+	}
+	return nil
+}
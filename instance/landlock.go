@@ -0,0 +1,107 @@
+package instance
+
+import (
+	"debug/elf"
+	"encoding/json"
+	"errors"
+	"io"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/solovev/orange-app-runner/system"
+)
+
+// landlockAccess - права Landlock, соответствующие видам доступа политики файловой системы.
+var landlockAccess = map[string]uint64{
+	AccessRead:  system.LandlockReadFile | system.LandlockReadDir,
+	AccessWrite: system.LandlockReadFile | system.LandlockReadDir | system.LandlockWriteFile | system.LandlockTruncate,
+	AccessCreate: system.LandlockReadFile | system.LandlockReadDir | system.LandlockWriteFile | system.LandlockTruncate |
+		system.LandlockRemoveDir | system.LandlockRemoveFile | system.LandlockMakeChar | system.LandlockMakeDir |
+		system.LandlockMakeReg | system.LandlockMakeSock | system.LandlockMakeFifo | system.LandlockMakeBlock |
+		system.LandlockMakeSym | system.LandlockRefer,
+	AccessExec: system.LandlockExecute,
+}
+
+// landlockRuleset - набор правил Landlock, который executor применяет к себе перед запуском
+// целевой программы (путь -> права на него и вложенные файлы).
+type landlockRuleset struct {
+	Handled uint64            `json:"handled"`
+	Rules   map[string]uint64 `json:"rules"`
+}
+
+// landlockRuleset возвращает набор правил Landlock для политики файловой системы <policy>
+// или nil, если политика не задана или ядро не поддерживает Landlock ("--require-landlock"
+// в этом случае запрещает запуск).
+func (cfg *Config) landlockRuleset(policy *fsPolicy, processPath string) (*landlockRuleset, error) {
+	if policy == nil {
+		return nil, nil
+	}
+
+	abi := system.LandlockABI()
+	if abi == 0 {
+		if cfg.RequireLandlock {
+			return nil, errors.New("Landlock is not supported by the kernel")
+		}
+		log.Warnln("Landlock is not supported by the kernel, filesystem policy will be checked with ptrace only")
+		return nil, nil
+	}
+	log.Debugf("Filesystem policy is enforced by Landlock (ABI %d)\n", abi)
+
+	ruleset := &landlockRuleset{
+		Handled: system.LandlockHandledAccess(abi),
+		Rules:   make(map[string]uint64),
+	}
+	for access, paths := range policy.paths {
+		for _, path := range paths {
+			ruleset.Rules[path] |= landlockAccess[access]
+		}
+	}
+
+	// Исполняемый файл tracee и его загрузчик запускаются ядром, а не tracee, и политикой
+	// не проверяются.
+	program := system.LandlockExecute | system.LandlockReadFile
+	ruleset.Rules[processPath] |= program
+	if interpreter := elfInterpreter(processPath); len(interpreter) > 0 {
+		ruleset.Rules[interpreter] |= program
+	}
+	return ruleset, nil
+}
+
+// restrict применяет правила к текущему потоку, они наследуются запущенными им процессами.
+func (r *landlockRuleset) restrict() error {
+	return system.RestrictLandlock(r.Handled, r.Rules)
+}
+
+func (r *landlockRuleset) write(w io.Writer) error {
+	return json.NewEncoder(w).Encode(r)
+}
+
+func readLandlockRuleset(r io.Reader) (*landlockRuleset, error) {
+	var ruleset landlockRuleset
+	if err := json.NewDecoder(r).Decode(&ruleset); err != nil {
+		return nil, err
+	}
+	return &ruleset, nil
+}
+
+// elfInterpreter возвращает путь к загрузчику (PT_INTERP) программы <path> или пустую
+// строку для статически собранных программ и скриптов.
+func elfInterpreter(path string) string {
+	f, err := elf.Open(path)
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+
+	for _, prog := range f.Progs {
+		if prog.Type != elf.PT_INTERP {
+			continue
+		}
+		data := make([]byte, prog.Filesz)
+		if _, err := prog.ReadAt(data, 0); err != nil {
+			return ""
+		}
+		return strings.TrimRight(string(data), "\x00")
+	}
+	return ""
+}
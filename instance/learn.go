@@ -0,0 +1,120 @@
+package instance
+
+import (
+	"sort"
+
+	"github.com/solovev/orange-app-runner/system"
+)
+
+// learnedArgument - аргумент системного вызова, значения которого запоминаются при обучении.
+// Если программа использовала единственное значение (после маски <mask>), в политику
+// добавляется правило на этот аргумент вместо безусловного разрешения вызова.
+type learnedArgument struct {
+	syscall string
+	arg     int
+	mask    uint32
+}
+
+// learnedArguments: режим доступа (O_ACCMODE) в open и openat и семейство адресов socket.
+var learnedArguments = []learnedArgument{
+	{syscall: "openat", arg: 2, mask: 3},
+	{syscall: "open", arg: 1, mask: 3},
+	{syscall: "socket", arg: 0, mask: 0xffffffff},
+}
+
+// policyLearner собирает системные вызовы, значения аргументов, создание процессов и потоков
+// и запускаемые программы во время работы tracee ("oar learn").
+type policyLearner struct {
+	syscalls  map[string]bool
+	arguments map[learnedArgument]map[uint32]bool
+
+	fork    bool
+	threads bool
+	exec    map[string]bool
+}
+
+func newPolicyLearner() *policyLearner {
+	return &policyLearner{
+		syscalls:  make(map[string]bool),
+		arguments: make(map[learnedArgument]map[uint32]bool),
+		exec:      make(map[string]bool),
+	}
+}
+
+// syscall запоминает системный вызов, на котором остановлен процесс <pid>. Аргументы на x86-64
+// сохраняются до выхода из вызова, поэтому входы и выходы не различаются.
+func (l *policyLearner) syscall(pid int) error {
+	state, err := system.GetSyscallState(pid)
+	if err != nil {
+		return err
+	}
+	if state.Nr < 0 {
+		// Остановка без системного вызова (orig_rax = -1), например, после перезапуска вызова.
+		return nil
+	}
+	name := system.SyscallName(state.Nr)
+	l.syscalls[name] = true
+
+	for _, arg := range learnedArguments {
+		if arg.syscall != name {
+			continue
+		}
+		values, ok := l.arguments[arg]
+		if !ok {
+			values = make(map[uint32]bool)
+			l.arguments[arg] = values
+		}
+		values[uint32(state.Args[arg.arg])&arg.mask] = true
+	}
+	return nil
+}
+
+func (l *policyLearner) spawned(thread bool) {
+	if thread {
+		l.threads = true
+	} else {
+		l.fork = true
+	}
+}
+
+func (l *policyLearner) executed(path string) {
+	l.exec[path] = true
+}
+
+// policy возвращает политику, разрешающую все, что использовал tracee.
+func (l *policyLearner) policy() *Policy {
+	policy := &Policy{Action: ActionTrace}
+
+	conditional := make(map[string]bool)
+	for _, arg := range learnedArguments {
+		values := l.arguments[arg]
+		if len(values) != 1 {
+			continue
+		}
+		for value := range values {
+			rule := ArgumentRule{Syscall: arg.syscall, Arg: arg.arg, Op: system.SeccompArgMaskedEqual, Mask: arg.mask, Value: value}
+			if arg.mask == 0xffffffff {
+				rule.Op, rule.Mask = system.SeccompArgEqual, 0
+			}
+			policy.Arguments = append(policy.Arguments, rule)
+		}
+		conditional[arg.syscall] = true
+	}
+
+	for name := range l.syscalls {
+		// execve разрешается фильтром всегда, запуск программ задается правилами процессов.
+		if !conditional[name] && name != "execve" {
+			policy.Syscalls.Allow = append(policy.Syscalls.Allow, name)
+		}
+	}
+	sort.Strings(policy.Syscalls.Allow)
+
+	policy.Processes.Fork = l.fork
+	policy.Processes.Threads = l.threads
+	policy.Processes.Exec = len(l.exec) > 0
+	for path := range l.exec {
+		policy.Processes.ExecPaths = append(policy.Processes.ExecPaths, path)
+	}
+	sort.Strings(policy.Processes.ExecPaths)
+	return policy
+}
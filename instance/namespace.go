@@ -0,0 +1,310 @@
+package instance
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/solovev/orange-app-runner/system"
+)
+
+// networkWait - время ожидания настройки сети "netsetgo".
+const networkWait = 3 * time.Second
+
+// defaultDevices - устройства /dev корневой ФС, если /dev не смонтирован параметрами.
+var defaultDevices = []string{"null", "zero", "urandom", "full"}
+
+// tmpfsSize - размер tmpfs: байты, килобайты, мегабайты, гигабайты или процент памяти.
+var tmpfsSize = regexp.MustCompile(`^[0-9]+[kKmMgG%]?$`)
+
+// mount - точка монтирования корневой ФС ("--bind" или "--tmpfs"). Пустой <source> - tmpfs.
+type mount struct {
+	source   string
+	target   string
+	readOnly bool
+	size     string
+}
+
+// mounts разбирает параметры "--bind src:dst[:ro]" и "--tmpfs dst:size" и возвращает их в порядке
+// монтирования: по глубине пути назначения, при равной глубине - привязки раньше tmpfs.
+// Точки монтирования внутри привязки только для чтения недопустимы: их негде создать.
+func (cfg *Config) mounts() ([]mount, error) {
+	var mounts []mount
+	for _, bind := range cfg.Binds {
+		parts := strings.Split(bind, ":")
+		if len(parts) < 2 || len(parts) > 3 || len(parts[0]) == 0 || (len(parts) == 3 && parts[2] != "ro") {
+			return nil, fmt.Errorf("Invalid bind mount \"%s\", expected src:dst[:ro]", bind)
+		}
+		source, err := filepath.Abs(parts[0])
+		if err != nil {
+			return nil, err
+		}
+		if _, err := os.Stat(source); err != nil {
+			return nil, err
+		}
+		mounts = append(mounts, mount{source: source, target: parts[1], readOnly: len(parts) == 3})
+	}
+	for _, tmpfs := range cfg.TmpFS {
+		parts := strings.Split(tmpfs, ":")
+		if len(parts) != 2 || !tmpfsSize.MatchString(parts[1]) {
+			return nil, fmt.Errorf("Invalid tmpfs mount \"%s\", expected dst:size", tmpfs)
+		}
+		mounts = append(mounts, mount{target: parts[0], size: parts[1]})
+	}
+
+	for i := range mounts {
+		target := mounts[i].target
+		if !filepath.IsAbs(target) {
+			return nil, fmt.Errorf("Mount destination \"%s\" must be an absolute path", target)
+		}
+		mounts[i].target = filepath.Clean(target)
+	}
+
+	// Родительские точки монтирования монтируются раньше вложенных, иначе скрыли бы их.
+	sort.SliceStable(mounts, func(i, j int) bool {
+		return mountDepth(mounts[i].target) < mountDepth(mounts[j].target)
+	})
+
+	for i, m := range mounts {
+		for _, parent := range mounts[:i] {
+			if parent.readOnly && isSubpath(m.target, parent.target) {
+				return nil, fmt.Errorf("Mount destination \"%s\" is inside read-only bind mount \"%s\"", m.target, parent.target)
+			}
+		}
+	}
+	return mounts, nil
+}
+
+// mountDepth возвращает число компонентов абсолютного пути <path> ("/" - 0).
+func mountDepth(path string) int {
+	if path == "/" {
+		return 0
+	}
+	return strings.Count(path, "/")
+}
+
+// isSubpath возвращает true, если <path> совпадает с <parent> или находится внутри него.
+func isSubpath(path, parent string) bool {
+	return path == parent || parent == "/" || strings.HasPrefix(path, parent+"/")
+}
+
+// CheckMounts проверяет параметры "--bind" и "--tmpfs", которые применяются только к корневой ФС.
+func (cfg *Config) CheckMounts() error {
+	if len(cfg.Binds) == 0 && len(cfg.TmpFS) == 0 {
+		return nil
+	}
+	if len(cfg.RootFS) == 0 {
+		return errors.New("Options \"--bind\" and \"--tmpfs\" require \"--rootfs\"")
+	}
+	_, err := cfg.mounts()
+	return err
+}
+
+// rootfsMounts - точки монтирования, добавленные tracer'ом в общую корневую ФС, и созданные
+// для них в ней каталоги и файлы, которые удаляются после запуска.
+type rootfsMounts struct {
+	// root - путь к корневой ФС до pivot_root, после него - пустой.
+	root    string
+	targets []string
+	created []string
+}
+
+// release отмонтирует точки монтирования и удаляет созданные для них каталоги и файлы
+// (начиная с вложенных). Непустые каталоги, в которые записал tracee, остаются.
+func (r *rootfsMounts) release() {
+	for i := len(r.targets) - 1; i >= 0; i-- {
+		syscall.Unmount(filepath.Join(r.root, r.targets[i]), syscall.MNT_DETACH)
+	}
+	for i := len(r.created) - 1; i >= 0; i-- {
+		path := filepath.Join(r.root, r.created[i])
+		if err := os.Remove(path); err != nil {
+			log.Debugf("Unable to remove mount point \"%s\": %v\n", path, err)
+		}
+	}
+}
+
+// create создает в корневой ФС каталог (<dir>) или пустой файл <target> вместе с недостающими
+// родительскими каталогами и запоминает созданные пути.
+func (r *rootfsMounts) create(target string, dir bool) error {
+	var missing []string
+	for path := target; path != "/"; path = filepath.Dir(path) {
+		if _, err := os.Lstat(filepath.Join(r.root, path)); !os.IsNotExist(err) {
+			break
+		}
+		missing = append([]string{path}, missing...)
+	}
+
+	for i, path := range missing {
+		full := filepath.Join(r.root, path)
+		if i == len(missing)-1 && !dir {
+			f, err := os.OpenFile(full, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+			if err != nil {
+				return err
+			}
+			f.Close()
+		} else if err := os.Mkdir(full, 0755); err != nil {
+			return err
+		}
+		r.created = append(r.created, path)
+	}
+	return nil
+}
+
+// setupMounts монтирует /dev и точки монтирования параметров в корневую ФС <root>.
+// Недостающие точки монтирования создаются в ней и удаляются release после запуска.
+func (cfg *Config) setupMounts(r *rootfsMounts) error {
+	mounts, err := cfg.mounts()
+	if err != nil {
+		return err
+	}
+
+	dev := true
+	for _, m := range mounts {
+		if m.target == "/dev" {
+			dev = false
+		}
+	}
+	if dev {
+		if err := r.create("/dev", true); err != nil {
+			return err
+		}
+		if err := system.MountDev(r.root, defaultDevices); err != nil {
+			return fmt.Errorf("Failed to mount /dev in \"%s\": %v", r.root, err)
+		}
+		r.targets = append(r.targets, "/dev")
+	}
+
+	for _, m := range mounts {
+		target := filepath.Join(r.root, m.target)
+		if len(m.source) == 0 {
+			if err := r.create(m.target, true); err != nil {
+				return err
+			}
+			log.Debugf("Mounting tmpfs (%s) to \"%s\"\n", m.size, m.target)
+			if err := system.MountTmpfs(target, m.size); err != nil {
+				return fmt.Errorf("Failed to mount tmpfs to \"%s\": %v", m.target, err)
+			}
+			r.targets = append(r.targets, m.target)
+			continue
+		}
+
+		info, err := os.Stat(m.source)
+		if err != nil {
+			return err
+		}
+		if err := r.create(m.target, info.IsDir()); err != nil {
+			return err
+		}
+		log.Debugf("Mounting \"%s\" to \"%s\" (read-only: %t)\n", m.source, m.target, m.readOnly)
+		if err := system.BindMount(m.source, target, m.readOnly); err != nil {
+			return fmt.Errorf("Failed to bind \"%s\" to \"%s\": %v", m.source, m.target, err)
+		}
+		r.targets = append(r.targets, m.target)
+	}
+	return nil
+}
+
+// SysProcAttr возвращает атрибуты запуска tracer'а: новые пространства имен UTS, IPC, PID, NET, USER
+// (и mount, если указана корневая ФС) с отображением текущего пользователя в root.
+func (cfg *Config) SysProcAttr() *syscall.SysProcAttr {
+	var cf uintptr
+	cf = syscall.CLONE_NEWUTS |
+		syscall.CLONE_NEWIPC |
+		syscall.CLONE_NEWPID |
+		syscall.CLONE_NEWNET |
+		syscall.CLONE_NEWUSER
+
+	if len(cfg.RootFS) > 0 {
+		cf |= syscall.CLONE_NEWNS
+	}
+
+	return &syscall.SysProcAttr{
+		Cloneflags: cf,
+		UidMappings: []syscall.SysProcIDMap{
+			{
+				ContainerID: 0,
+				HostID:      os.Getuid(),
+				Size:        1,
+			},
+		},
+		GidMappings: []syscall.SysProcIDMap{
+			{
+				ContainerID: 0,
+				HostID:      os.Getgid(),
+				Size:        1,
+			},
+		},
+	}
+}
+
+// StartNetSetGo настраивает сеть в пространстве имен tracer'а <pid> бинарным файлом "netsetgo".
+func (cfg *Config) StartNetSetGo(pid int) error {
+	if len(cfg.NetSetGoPath) == 0 {
+		return nil
+	}
+
+	args := []string{"-pid", strconv.Itoa(pid)}
+	log.Infof("Starting \"netsetgo\" (%s), args: %v\n", cfg.NetSetGoPath, args)
+
+	if err := exec.Command(cfg.NetSetGoPath, args...).Run(); err != nil {
+		return fmt.Errorf("Error starting \"netsetgo\" binary: %v", err)
+	}
+	return nil
+}
+
+// SetupNamespaces вызывается tracer'ом в новых пространствах имен: монтирует /proc, /dev и точки
+// монтирования "--bind" и "--tmpfs" и выполняет pivot_root в корневую ФС (если указана),
+// устанавливает <hostname> и ждет настройки сети. Возвращаемая функция вызывается после
+// запуска и удаляет из корневой ФС созданные для точек монтирования каталоги и файлы.
+func (cfg *Config) SetupNamespaces(hostname string) (func(), error) {
+	release := func() {}
+	if len(cfg.RootFS) > 0 {
+		path, err := filepath.Abs(cfg.RootFS)
+		if err != nil {
+			return nil, err
+		}
+
+		log.Infof("Root filesystem path: \"%s\"\n", path)
+
+		if err := system.MountProc(path); err != nil {
+			return nil, fmt.Errorf("Failed to mount /proc in \"%s\": %v", path, err)
+		}
+
+		mounts := &rootfsMounts{root: path}
+		if err := cfg.setupMounts(mounts); err != nil {
+			mounts.release()
+			return nil, err
+		}
+
+		if err := system.PivotRoot(path); err != nil {
+			mounts.release()
+			return nil, fmt.Errorf("Error running pivot_root to \"%s\": %v", path, err)
+		}
+		mounts.root = ""
+		release = mounts.release
+	}
+
+	log.Infof("Setting hostname: \"%s\"\n", hostname)
+	if err := syscall.Sethostname([]byte(hostname)); err != nil {
+		release()
+		return nil, fmt.Errorf("Error setting hostname \"%s\": %v", hostname, err)
+	}
+
+	if len(cfg.NetSetGoPath) > 0 {
+		log.Infof("Waiting for network for %v...\n", networkWait)
+		if err := system.WaitForNetwork(networkWait); err != nil {
+			release()
+			return nil, fmt.Errorf("Error waiting for network: %v", err)
+		}
+	}
+	return release, nil
+}
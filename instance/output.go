@@ -0,0 +1,210 @@
+package instance
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Потоки, в которых может быть превышено ограничение на размер вывода.
+const (
+	OutputStdout = "stdout"
+	OutputStderr = "stderr"
+	OutputFile   = "file"
+)
+
+// outputRelayTimeout - время ожидания закрытия pipe'ов после завершения tracee.
+// Pipe может оставаться открытым, если его унаследовал еще работающий потомок tracee.
+const outputRelayTimeout = time.Second
+
+// outputRelay пересылает вывод tracee из pipe'а в файл назначения, подсчитывая количество байт.
+// При превышении ограничения tracee завершается, а остаток вывода отбрасывается.
+type outputRelay struct {
+	stream string
+	limit  int64
+
+	r, w *os.File
+	dst  *os.File
+
+	done chan struct{}
+}
+
+// prepareOutputRelays заменяет stdout и stderr в <files> на pipe'ы с ограничением <limit> байт на каждый поток.
+func prepareOutputRelays(files []*os.File, limit int64) ([]*os.File, []*outputRelay, error) {
+	result := append([]*os.File{}, files...)
+
+	var relays []*outputRelay
+	for fd, stream := range map[int]string{1: OutputStdout, 2: OutputStderr} {
+		relay, err := newOutputRelay(stream, result[fd], limit)
+		if err != nil {
+			closeOutputRelays(relays)
+			return nil, nil, err
+		}
+		result[fd] = relay.w
+		relays = append(relays, relay)
+	}
+	return result, relays, nil
+}
+
+// newOutputRelay создает pipe для потока <stream>. Дескриптор <dst> дублируется,
+// поэтому вызывающий может закрыть его сразу после запуска tracee.
+func newOutputRelay(stream string, dst *os.File, limit int64) (*outputRelay, error) {
+	fd, err := syscall.Dup(int(dst.Fd()))
+	if err != nil {
+		return nil, fmt.Errorf("Unable to duplicate %s descriptor: %v", stream, err)
+	}
+	syscall.CloseOnExec(fd)
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		syscall.Close(fd)
+		return nil, err
+	}
+
+	return &outputRelay{
+		stream: stream,
+		limit:  limit,
+		r:      r,
+		w:      w,
+		dst:    os.NewFile(uintptr(fd), stream),
+		done:   make(chan struct{}),
+	}, nil
+}
+
+// start закрывает копию pipe'а, переданную tracee, и начинает пересылку.
+func (r *outputRelay) start(tracee *traceeInstance) {
+	r.w.Close()
+	go r.run(tracee)
+}
+
+func (r *outputRelay) run(tracee *traceeInstance) {
+	defer close(r.done)
+	defer r.dst.Close()
+
+	written, err := io.Copy(r.dst, io.LimitReader(r.r, r.limit))
+	if err != nil {
+		log.Debugf("[Relay %s] Unable to forward output: %v\n", r.stream, err)
+	} else if written == r.limit {
+		var b [1]byte
+		if n, _ := r.r.Read(b[:]); n > 0 {
+			log.Debugf("[Relay %s] Output limit (%d bytes) was exceeded\n", r.stream, r.limit)
+			tracee.kill(outputLimitError("outputRelay", r.stream))
+		}
+	}
+
+	if _, err := io.Copy(ioutil.Discard, r.r); err != nil {
+		log.Debugf("[Relay %s] Draining error: %v\n", r.stream, err)
+	}
+}
+
+// wait дожидается окончания пересылки. Если pipe не закрыт за <timeout>, он закрывается принудительно.
+func (r *outputRelay) wait(timeout time.Duration) {
+	select {
+	case <-r.done:
+	case <-time.After(timeout):
+		log.Debugf("[Relay %s] Pipe is still open after tracee termination, closing it\n", r.stream)
+		r.r.Close()
+		<-r.done
+	}
+	r.r.Close()
+}
+
+func waitOutputRelays(relays []*outputRelay) {
+	for _, relay := range relays {
+		relay.wait(outputRelayTimeout)
+	}
+}
+
+// closeOutputRelays освобождает ресурсы пересылок, которые не были запущены.
+func closeOutputRelays(relays []*outputRelay) {
+	for _, relay := range relays {
+		relay.r.Close()
+		relay.w.Close()
+		relay.dst.Close()
+	}
+}
+
+// dirOutput подсчитывает, сколько байт tracee записал в файлы рабочего каталога (вместе
+// с подкаталогами): RLIMIT_FSIZE ограничивает только каждый файл в отдельности.
+type dirOutput struct {
+	dir string
+	// initial - размеры файлов до запуска tracee.
+	initial map[string]int64
+}
+
+func newDirOutput(dir string) (*dirOutput, error) {
+	initial, err := fileSizes(dir)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to read working directory \"%s\": %v", dir, err)
+	}
+	return &dirOutput{dir: dir, initial: initial}, nil
+}
+
+// written возвращает суммарный прирост размеров файлов каталога с момента запуска tracee.
+func (o *dirOutput) written() int64 {
+	sizes, _ := fileSizes(o.dir)
+
+	var total int64
+	for path, size := range sizes {
+		if size > o.initial[path] {
+			total += size - o.initial[path]
+		}
+	}
+	return total
+}
+
+// fileSizes возвращает размеры обычных файлов в каталоге <dir> и его подкаталогах.
+// Недоступные файлы и каталоги пропускаются, символические ссылки не раскрываются.
+func fileSizes(dir string) (map[string]int64, error) {
+	sizes := make(map[string]int64)
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			if path == dir {
+				return err
+			}
+			return nil
+		}
+		if info.Mode().IsRegular() {
+			sizes[path] = info.Size()
+		}
+		return nil
+	})
+	return sizes, err
+}
+
+// startCheckingOutput завершает tracee, если суммарный размер записанных в рабочий каталог
+// файлов превысил ограничение "--output-limit".
+func startCheckingOutput(tracee *traceeInstance, output *dirOutput, limit int64) {
+	tracee.wg.Add(1)
+	defer tracee.wg.Done()
+
+	log.Debugln("Goroutine \"startCheckingOutput\" started")
+	defer log.Debugln("Goroutine \"startCheckingOutput\" terminated")
+
+	ticker := time.NewTicker(500 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-tracee.stopc:
+			return
+		case <-ticker.C:
+			if written := output.written(); written > limit {
+				log.Debugf("Tracee wrote %d bytes to files in \"%s\"\n", written, output.dir)
+				tracee.kill(outputLimitError("startCheckingOutput", OutputFile))
+				return
+			}
+		}
+	}
+}
+
+func outputLimitError(tag, stream string) *TracerError {
+	tErr := createTracerError(tag, ErrOutputLimitExceeded)
+	tErr.Stream = stream
+	return tErr
+}
@@ -0,0 +1,153 @@
+package instance
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Policy описывает набор правил запуска для отдельного языка (среды выполнения):
+// разрешенные системные вызовы, условия на их аргументы, правила создания процессов
+// и действие при нарушении.
+type Policy struct {
+	Name      string         `yaml:"name" json:"name"`
+	Action    string         `yaml:"action" json:"action"`
+	Syscalls  SyscallList    `yaml:"syscalls" json:"syscalls"`
+	Arguments []ArgumentRule `yaml:"arguments" json:"arguments"`
+	Processes ProcessRules   `yaml:"processes" json:"processes"`
+}
+
+type SyscallList struct {
+	Allow []string `yaml:"allow" json:"allow"`
+	Deny  []string `yaml:"deny" json:"deny"`
+}
+
+// ArgumentRule разрешает системный вызов <Syscall> только если его аргумент с индексом <Arg>
+// удовлетворяет условию: "eq" - равен <Value>, "ne" - не равен <Value>,
+// "masked_eq" - после побитового "И" с <Mask> равен <Value>.
+// Несколько правил для одного вызова должны выполняться одновременно.
+type ArgumentRule struct {
+	Syscall string `yaml:"syscall" json:"syscall"`
+	Arg     int    `yaml:"arg" json:"arg"`
+	Op      string `yaml:"op" json:"op"`
+	Mask    uint32 `yaml:"mask" json:"mask"`
+	Value   uint32 `yaml:"value" json:"value"`
+}
+
+// ProcessRules разрешает создание процессов, потоков и запуск программ. Если список <ExecPaths>
+// не пуст, запускать можно только перечисленные исполняемые файлы.
+type ProcessRules struct {
+	Fork      bool     `yaml:"fork" json:"fork"`
+	Threads   bool     `yaml:"threads" json:"threads"`
+	Exec      bool     `yaml:"exec" json:"exec"`
+	ExecPaths []string `yaml:"exec_paths,omitempty" json:"exec_paths,omitempty"`
+}
+
+// LoadPolicy загружает политику из YAML или JSON файла <nameOrPath>.
+// Если такого файла нет, ищется встроенная политика с этим именем.
+func LoadPolicy(nameOrPath string) (*Policy, error) {
+	data, err := ioutil.ReadFile(nameOrPath)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			return nil, err
+		}
+		builtin, ok := builtinPolicies[nameOrPath]
+		if !ok {
+			return nil, fmt.Errorf("Policy file \"%s\" does not exist and it is not one of the built-in policies %v", nameOrPath, BuiltinPolicies())
+		}
+		return ParsePolicy([]byte(builtin), false)
+	}
+
+	return ParsePolicy(data, filepath.Ext(nameOrPath) == ".json")
+}
+
+// ParsePolicy разбирает политику в формате JSON (<isJSON>) или YAML.
+func ParsePolicy(data []byte, isJSON bool) (*Policy, error) {
+	policy := &Policy{}
+
+	var err error
+	if isJSON {
+		// Неизвестные ключи (например, опечатка в "syscalls") не должны незаметно ослаблять политику.
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		decoder.DisallowUnknownFields()
+		err = decoder.Decode(policy)
+	} else {
+		err = yaml.UnmarshalStrict(data, policy)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Unable to parse policy: %v", err)
+	}
+
+	if len(policy.Action) > 0 {
+		if _, err := seccompAction(policy.Action); err != nil {
+			return nil, err
+		}
+	}
+	return policy, nil
+}
+
+// Write записывает политику в <w> в формате JSON (<isJSON>) или YAML.
+func (p *Policy) Write(w io.Writer, isJSON bool) error {
+	if isJSON {
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(p)
+	}
+
+	data, err := yaml.Marshal(p)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	return err
+}
+
+// execAllowed возвращает false, если политика ограничивает запускаемые программы и <path>
+// не входит в их число.
+func (p *Policy) execAllowed(path string) bool {
+	if p == nil || len(p.Processes.ExecPaths) == 0 {
+		return true
+	}
+	for _, allowed := range p.Processes.ExecPaths {
+		if allowed == path {
+			return true
+		}
+	}
+	return false
+}
+
+// BuiltinPolicies возвращает имена встроенных политик.
+func BuiltinPolicies() []string {
+	var names []string
+	for name := range builtinPolicies {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// LoadPolicy загружает политику, указанную в параметре "--policy", и применяет
+// ее правила создания процессов к конфигурации.
+func (cfg *Config) LoadPolicy() error {
+	if len(cfg.PolicyPath) == 0 {
+		return nil
+	}
+
+	policy, err := LoadPolicy(cfg.PolicyPath)
+	if err != nil {
+		return err
+	}
+
+	cfg.Policy = policy
+	cfg.AllowCreateProcesses = cfg.AllowCreateProcesses || policy.Processes.Fork
+	cfg.AllowMultiThreading = cfg.AllowMultiThreading || policy.Processes.Threads
+	cfg.AllowExec = cfg.AllowExec || policy.Processes.Exec
+	return nil
+}
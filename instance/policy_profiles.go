@@ -0,0 +1,111 @@
+package instance
+
+// builtinPolicies содержит политики для распространенных языков, их можно указать
+// в параметре "--policy" по имени. Файлы открываются только на чтение там, где это
+// допускает среда выполнения; запись разрешена только в уже открытые дескрипторы.
+var builtinPolicies = map[string]string{
+	"cpp":    cppPolicy,
+	"python": pythonPolicy,
+	"java":   javaPolicy,
+	"go":     goPolicy,
+}
+
+// cppPolicy подходит для программ на C и C++ (glibc, статическая и динамическая линковка).
+const cppPolicy = `
+name: cpp
+action: TRACE
+syscalls:
+  allow: [
+    read, write, readv, writev, pread64, pwrite64, lseek, close, fadvise64,
+    fstat, newfstatat, stat, lstat, statx, access, faccessat, faccessat2,
+    readlink, readlinkat, getcwd, ioctl, fcntl, dup, dup2, dup3,
+    brk, mmap, munmap, mprotect, mremap, madvise,
+    arch_prctl, set_tid_address, set_robust_list, rseq, prlimit64, getrlimit,
+    getrandom, uname, sysinfo, getpid, gettid, getuid, geteuid, getgid, getegid,
+    rt_sigaction, rt_sigprocmask, rt_sigreturn, sigaltstack, tgkill,
+    futex, clock_gettime, clock_getres, gettimeofday, time, nanosleep, clock_nanosleep,
+    sched_getaffinity, sched_yield, exit, exit_group
+  ]
+arguments:
+  # O_ACCMODE (3) == O_RDONLY (0)
+  - {syscall: openat, arg: 2, op: masked_eq, mask: 3, value: 0}
+  - {syscall: open, arg: 1, op: masked_eq, mask: 3, value: 0}
+processes:
+  fork: false
+  threads: false
+  exec: false
+`
+
+// pythonPolicy подходит для CPython 3. Интерпретатор при импорте модулей может пытаться
+// записывать кэш байткода, поэтому вызов "openat" не ограничен (рекомендуется PYTHONDONTWRITEBYTECODE=1).
+const pythonPolicy = `
+name: python
+action: TRACE
+syscalls:
+  allow: [
+    read, write, readv, writev, pread64, pwrite64, lseek, close, fadvise64,
+    open, openat, fstat, newfstatat, stat, lstat, statx, access, faccessat, faccessat2,
+    readlink, readlinkat, getcwd, getdents64, ioctl, fcntl, dup, dup2, dup3, pipe2,
+    brk, mmap, munmap, mprotect, mremap, madvise,
+    arch_prctl, set_tid_address, set_robust_list, rseq, prlimit64, getrlimit,
+    getrandom, uname, sysinfo, getpid, gettid, getppid, getuid, geteuid, getgid, getegid,
+    rt_sigaction, rt_sigprocmask, rt_sigreturn, sigaltstack, tgkill,
+    futex, clock_gettime, clock_getres, gettimeofday, time, nanosleep, clock_nanosleep,
+    sched_getaffinity, sched_yield, exit, exit_group
+  ]
+processes:
+  fork: false
+  threads: false
+  exec: false
+`
+
+// javaPolicy подходит для OpenJDK. JVM всегда многопоточна, а лаунчер "java"
+// может перезапустить сам себя, поэтому потоки и exec разрешены.
+const javaPolicy = `
+name: java
+action: TRACE
+syscalls:
+  allow: [
+    read, write, readv, writev, pread64, pwrite64, lseek, close, fadvise64,
+    open, openat, fstat, newfstatat, stat, lstat, statx, statfs, fstatfs,
+    access, faccessat, faccessat2, readlink, readlinkat, getcwd, getdents64,
+    ioctl, fcntl, dup, dup2, dup3, pipe, pipe2, ftruncate, unlink, mkdir, memfd_create,
+    brk, mmap, munmap, mprotect, mremap, madvise, mincore, membarrier,
+    arch_prctl, prctl, set_tid_address, set_robust_list, rseq, prlimit64, getrlimit, getrusage,
+    getrandom, uname, sysinfo, getpid, gettid, getppid, getuid, geteuid, getgid, getegid,
+    rt_sigaction, rt_sigprocmask, rt_sigreturn, rt_sigtimedwait, sigaltstack, tgkill,
+    futex, clock_gettime, clock_getres, gettimeofday, time, nanosleep, clock_nanosleep,
+    sched_getaffinity, sched_setaffinity, sched_yield, sched_getparam, sched_getscheduler,
+    clone, clone3, exit, exit_group
+  ]
+processes:
+  fork: false
+  threads: true
+  exec: true
+`
+
+// goPolicy подходит для статически собранных программ на Go.
+const goPolicy = `
+name: go
+action: TRACE
+syscalls:
+  allow: [
+    read, write, readv, writev, pread64, pwrite64, lseek, close, fadvise64,
+    fstat, newfstatat, stat, lstat, statx, readlinkat, getcwd, ioctl, fcntl, dup3,
+    mmap, munmap, mprotect, madvise, mincore,
+    arch_prctl, prctl, prlimit64, getrlimit, getrandom, uname, getpid, gettid, getppid,
+    getuid, geteuid, getgid, getegid,
+    rt_sigaction, rt_sigprocmask, rt_sigreturn, sigaltstack, tgkill,
+    futex, clock_gettime, gettimeofday, nanosleep, clock_nanosleep,
+    sched_getaffinity, sched_yield,
+    epoll_create1, epoll_ctl, epoll_pwait, epoll_wait, pipe2, eventfd2,
+    clone, exit, exit_group
+  ]
+arguments:
+  # O_ACCMODE (3) == O_RDONLY (0)
+  - {syscall: openat, arg: 2, op: masked_eq, mask: 3, value: 0}
+processes:
+  fork: false
+  threads: true
+  exec: false
+`
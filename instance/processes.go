@@ -0,0 +1,319 @@
+package instance
+
+import (
+	"sync"
+	"syscall"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/solovev/orange-app-runner/system"
+)
+
+// Способы учета памяти для ограничения "--mem-limit".
+const (
+	// MemoryHWM - пиковый размер резидентной памяти (VmHWM, ru_maxrss).
+	MemoryHWM = "hwm"
+	// MemoryRSS - текущий размер резидентной памяти (VmRSS).
+	MemoryRSS = "rss"
+	// MemoryAddressSpace - пиковый размер адресного пространства (VmPeak).
+	MemoryAddressSpace = "vm"
+)
+
+// processMemory возвращает память процесса в килобайтах согласно способу учета <accounting>.
+func processMemory(status *system.ProcessStatus, accounting string) int64 {
+	switch accounting {
+	case MemoryRSS:
+		return status.VmRSS
+	case MemoryAddressSpace:
+		return status.VmPeak
+	default:
+		return status.VmHWM
+	}
+}
+
+// ProcessInfo - сведения о процессе из дерева tracee для отчета. Время и память берутся
+// из wait4 и, как в getrusage(RUSAGE_BOTH), включают потомков, которых процесс дождался.
+type ProcessInfo struct {
+	PID    int `json:"pid"`
+	Parent int `json:"parent"`
+	// Threads - число потоков, созданных процессом после последнего exec, включая основной.
+	Threads int `json:"threads"`
+
+	UserTime   float64 `json:"cpu_user_time"`
+	SystemTime float64 `json:"cpu_system_time"`
+	PeakRSS    int64   `json:"peak_rss"`
+
+	Exited     bool `json:"exited"`
+	ExitStatus int  `json:"exit_status"`
+	Signal     int  `json:"signal,omitempty"`
+}
+
+// processEntry - процесс в таблице и его номер в /proc (0, если еще не известен).
+type processEntry struct {
+	info      ProcessInfo
+	procfsPid int
+	// childTime - итоговое время процессора (мс) завершившихся потомков, которых процесс
+	// должен дождаться.
+	childTime float64
+}
+
+// processTable - таблица процессов дерева tracee по pid, которую trace заполняет по событиям
+// ptrace (fork, vfork, clone, exit). Потоки (clone) учитываются в процессе-владельце.
+//
+// Сумма по дереву: для живых процессов - последние значения wait4 (процесс и дождавшиеся
+// его завершения потомки), плюс итоговые значения процессов, которых дождался сам tracer
+// (tracee и потомки, оставшиеся без родителя). Так каждый процесс учитывается один раз.
+type processTable struct {
+	mu sync.Mutex
+
+	processes map[int]*processEntry
+	// threads - владелец каждого потока, кроме основных.
+	threads map[int]int
+	// all - все процессы в порядке появления, в том числе завершившиеся.
+	all []*processEntry
+
+	reapedTime float64
+	reapedRSS  int64
+
+	// spawnc получает уведомление при появлении нового процесса.
+	spawnc chan struct{}
+}
+
+func newProcessTable(root int) *processTable {
+	t := &processTable{
+		processes: make(map[int]*processEntry),
+		threads:   make(map[int]int),
+		spawnc:    make(chan struct{}, 1),
+	}
+	t.add(root, 0)
+	return t
+}
+
+func (t *processTable) add(pid, parent int) *processEntry {
+	entry := &processEntry{info: ProcessInfo{PID: pid, Parent: parent, Threads: 1, ExitStatus: -1}}
+	t.processes[pid] = entry
+	t.all = append(t.all, entry)
+	return entry
+}
+
+// owner возвращает процесс, которому принадлежит поток <pid>. Неизвестный pid (первая остановка
+// потомка может прийти раньше события родителя) временно добавляется как процесс без родителя.
+func (t *processTable) owner(pid int) *processEntry {
+	if owner, ok := t.threads[pid]; ok {
+		pid = owner
+	}
+	if entry, ok := t.processes[pid]; ok {
+		return entry
+	}
+	return t.add(pid, 0)
+}
+
+// spawned регистрирует потомка <child>, созданного потоком <pid>: процесс (fork, vfork)
+// или поток (clone).
+func (t *processTable) spawned(pid, child int, thread bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	parent := t.owner(pid)
+	// Остановка потомка могла быть учтена раньше, чем событие родителя.
+	if entry, ok := t.processes[child]; ok && entry.info.Parent == 0 && !thread {
+		entry.info.Parent = parent.info.PID
+		return
+	}
+	if thread {
+		t.forget(child)
+		t.threads[child] = parent.info.PID
+		parent.info.Threads++
+		return
+	}
+	t.add(child, parent.info.PID)
+
+	select {
+	case t.spawnc <- struct{}{}:
+	default:
+	}
+}
+
+// forget удаляет ошибочно добавленный owner'ом процесс, оказавшийся потоком.
+func (t *processTable) forget(pid int) {
+	if _, ok := t.processes[pid]; !ok {
+		return
+	}
+	delete(t.processes, pid)
+	for i, entry := range t.all {
+		if entry.info.PID == pid && !entry.info.Exited {
+			t.all = append(t.all[:i], t.all[i+1:]...)
+			break
+		}
+	}
+}
+
+// exec учитывает exec в потоке <pid>: остальные потоки процесса при этом завершаются.
+func (t *processTable) exec(pid int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.owner(pid).info.Threads = 1
+}
+
+// update учитывает результат wait4 для потока <pid>: значения <usage> (по всему процессу)
+// и, если поток или процесс завершился, его статус.
+func (t *processTable) update(pid int, status syscall.WaitStatus, usage *syscall.Rusage) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	exited := status.Exited() || status.Signaled()
+	if _, ok := t.processes[pid]; !ok && exited && t.threads[pid] == 0 {
+		// Завершившийся процесс, родитель которого умер, не дождавшись его: tracer
+		// получает его повторно как новый родитель.
+		t.reapedTime += timevalToMs(usage.Utime) + timevalToMs(usage.Stime)
+		return
+	}
+
+	entry := t.owner(pid)
+	info := &entry.info
+	info.UserTime = timevalToMs(usage.Utime)
+	info.SystemTime = timevalToMs(usage.Stime)
+	if usage.Maxrss > info.PeakRSS {
+		info.PeakRSS = usage.Maxrss
+	}
+
+	if !exited {
+		return
+	}
+
+	if pid != info.PID {
+		delete(t.threads, pid)
+		return
+	}
+
+	info.Exited = true
+	if status.Exited() {
+		info.ExitStatus = status.ExitStatus()
+	} else {
+		info.Signal = int(status.Signal())
+	}
+	delete(t.processes, pid)
+
+	// Процесс без живого родителя в дереве дождался tracer: дальше его значения
+	// не войдут в значения родителя, поэтому учитываются отдельно.
+	if parent, ok := t.processes[info.Parent]; ok {
+		parent.childTime += info.UserTime + info.SystemTime
+	} else {
+		t.reapedTime += info.UserTime + info.SystemTime
+		if info.PeakRSS > t.reapedRSS {
+			t.reapedRSS = info.PeakRSS
+		}
+	}
+	log.Debugf("Process %d (parent %d) exited: %+v\n", pid, info.Parent, *info)
+}
+
+// usage возвращает суммарное время процессора (мс) и пиковую резидентную память (КБ) дерева
+// по данным wait4: сумму пиковых значений живых процессов или пик завершившихся, если он больше.
+func (t *processTable) usage() (float64, int64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	cpu, memory := t.reapedTime, int64(0)
+	for _, entry := range t.processes {
+		cpu += entry.info.UserTime + entry.info.SystemTime
+		memory += entry.info.PeakRSS
+	}
+	if t.reapedRSS > memory {
+		memory = t.reapedRSS
+	}
+	return cpu, memory
+}
+
+// procfs возвращает номер, под которым процесс или поток <pid> виден в /proc (см. system.ProcfsPid).
+func (t *processTable) procfs(pid int) (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	entry := t.owner(pid)
+	if entry.procfsPid == 0 {
+		procfsPid, err := system.ProcfsPid(entry.info.PID)
+		if err != nil {
+			return 0, err
+		}
+		entry.procfsPid = procfsPid
+	}
+	return entry.procfsPid, nil
+}
+
+// procfsUsage возвращает суммарное время процессора (мс) и память (КБ, согласно способу учета
+// <accounting>) живых процессов дерева по данным /proc, которые, в отличие от wait4, не зависят
+// от остановок процессов.
+func (t *processTable) procfsUsage(accounting string) (float64, int64, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	cpu, memory := t.reapedTime, int64(0)
+	for pid, entry := range t.processes {
+		if entry.procfsPid == 0 {
+			procfsPid, err := system.ProcfsPid(pid)
+			if err != nil {
+				// Процесс мог завершиться, а событие еще не обработано.
+				continue
+			}
+			entry.procfsPid = procfsPid
+		}
+
+		status, err := system.GetProcessStatus(entry.procfsPid)
+		if err != nil {
+			continue
+		}
+		ticks := status.UTime + status.STime + status.CUTime + status.CSTime
+		cpu += float64(ticks) * 1000.0 / system.ClockTicks
+		memory += processMemory(status, accounting)
+	}
+	return cpu, memory, nil
+}
+
+// cpuTime возвращает суммарное время процессора (мс) дерева с точностью часов процессорного
+// времени: время живых процессов, их завершившихся потомков и процессов, которых дождался tracer.
+// Также возвращаются номера живых процессов.
+func (t *processTable) cpuTime() (float64, []int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	cpu := t.reapedTime
+	pids := make([]int, 0, len(t.processes))
+	for pid, entry := range t.processes {
+		own, err := system.GetProcessCPUTime(pid)
+		if err != nil {
+			// Процесс завершился, а событие еще не обработано.
+			continue
+		}
+		cpu += float64(own.Nanoseconds())/float64(time.Millisecond) + entry.childTime
+		pids = append(pids, pid)
+	}
+	return cpu, pids
+}
+
+// tasks возвращает номера всех живых процессов и потоков дерева.
+func (t *processTable) tasks() []int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	result := make([]int, 0, len(t.processes)+len(t.threads))
+	for pid := range t.processes {
+		result = append(result, pid)
+	}
+	for tid := range t.threads {
+		result = append(result, tid)
+	}
+	return result
+}
+
+// list возвращает сведения о всех процессах дерева в порядке их появления.
+func (t *processTable) list() []ProcessInfo {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	result := make([]ProcessInfo, 0, len(t.all))
+	for _, entry := range t.all {
+		result = append(result, entry.info)
+	}
+	return result
+}
@@ -0,0 +1,145 @@
+package instance
+
+import (
+	"encoding/json"
+	"io"
+	"syscall"
+	"time"
+
+	"github.com/solovev/orange-app-runner/checker"
+)
+
+type Verdict string
+
+const (
+	VerdictOK                Verdict = "OK"
+	VerdictTimeLimit         Verdict = "TLE"
+	VerdictCPUTimeLimit      Verdict = "CPU-TLE"
+	VerdictMemoryLimit       Verdict = "MLE"
+	VerdictOutputLimit       Verdict = "OLE"
+	VerdictIdleLimit         Verdict = "ILE"
+	VerdictRuntimeError      Verdict = "RE"
+	VerdictSecurityViolation Verdict = "SV"
+	VerdictInternalError     Verdict = "IE"
+	VerdictWrongAnswer       Verdict = "WA"
+	VerdictPresentationError Verdict = "PE"
+)
+
+// Report содержит итоговую информацию о запуске tracee процесса.
+type Report struct {
+	Verdict Verdict `json:"verdict"`
+
+	// Время указывается в миллисекундах, память - в килобайтах.
+	WallTime   float64 `json:"wall_time"`
+	UserTime   float64 `json:"cpu_user_time"`
+	SystemTime float64 `json:"cpu_system_time"`
+	PeakRSS    int64   `json:"peak_rss"`
+	// CPUTime - суммарное время процессора всех процессов tracee.
+	CPUTime float64 `json:"cpu_time"`
+
+	// KillStage - этап завершения, который закончил работу tracee: "signal" ("--kill-signal")
+	// или "kill" (SIGKILL). Пусто, если tracee завершился сам.
+	KillStage string `json:"kill_stage,omitempty"`
+
+	ExitStatus int    `json:"exit_status"`
+	Signal     int    `json:"signal,omitempty"`
+	SignalName string `json:"signal_name,omitempty"`
+
+	Tag     string `json:"tag,omitempty"`
+	Error   string `json:"error,omitempty"`
+	Syscall string `json:"syscall,omitempty"`
+	// DeniedPath и DeniedAccess - путь и вид доступа ("read", "write", "create", "exec"),
+	// запрещенные политикой файловой системы ("--fs-*").
+	DeniedPath   string `json:"denied_path,omitempty"`
+	DeniedAccess string `json:"denied_access,omitempty"`
+	// Landlock - политика файловой системы применялась ядром (Landlock) в дополнение к проверке ptrace'ом.
+	Landlock bool `json:"landlock,omitempty"`
+	// LearnedPolicy - политика, собранная в режиме "oar learn".
+	LearnedPolicy *Policy `json:"learned_policy,omitempty"`
+	// RuntimeError - сигнал, завершивший tracee, при вердикте RE.
+	RuntimeError *RuntimeError `json:"runtime_error,omitempty"`
+	// OutputStream - поток ("stdout", "stderr" или "file"), в котором превышено ограничение на размер вывода.
+	OutputStream string `json:"output_stream,omitempty"`
+
+	Cgroup *CgroupStats `json:"cgroup,omitempty"`
+	// Processes - процессы дерева tracee в порядке их появления.
+	Processes []ProcessInfo `json:"processes,omitempty"`
+	// Check - результат сравнения stdout tracee с ответом ("--answer").
+	Check *checker.Result `json:"check,omitempty"`
+	// Interactor - отчет о запуске интерактора ("--interactor").
+	Interactor *Report `json:"interactor,omitempty"`
+}
+
+func newReport(started time.Time, status syscall.WaitStatus, usage *syscall.Rusage, err error) *Report {
+	report := &Report{
+		Verdict:    VerdictOK,
+		WallTime:   float64(time.Since(started).Nanoseconds()) / float64(time.Millisecond),
+		UserTime:   timevalToMs(usage.Utime),
+		SystemTime: timevalToMs(usage.Stime),
+		PeakRSS:    usage.Maxrss,
+		ExitStatus: -1,
+	}
+
+	switch {
+	case status.Exited():
+		report.ExitStatus = status.ExitStatus()
+		if report.ExitStatus != 0 {
+			report.Verdict = VerdictRuntimeError
+		}
+	case status.Signaled():
+		report.Signal = int(status.Signal())
+		report.SignalName = status.Signal().String()
+		report.Verdict = VerdictRuntimeError
+	}
+
+	if err != nil {
+		report.Error = err.Error()
+		report.Verdict = VerdictInternalError
+		if tErr, ok := err.(*TracerError); ok {
+			report.Tag = tErr.Tag
+			report.Syscall = tErr.Syscall
+			report.DeniedPath = tErr.Path
+			report.DeniedAccess = tErr.Access
+			report.OutputStream = tErr.Stream
+			report.RuntimeError = tErr.Runtime
+			if len(tErr.Verdict) > 0 {
+				report.Verdict = tErr.Verdict
+			}
+			if tErr.Parent != nil {
+				report.Error = tErr.Parent.Error()
+			}
+		}
+	}
+	return report
+}
+
+// FailedReport создает отчет для случая, когда tracer завершился, не успев его отправить.
+func FailedReport(err error) *Report {
+	report := &Report{
+		Verdict:    VerdictInternalError,
+		ExitStatus: -1,
+		Tag:        "tracer",
+	}
+	if err != nil {
+		report.Error = err.Error()
+	}
+	return report
+}
+
+func (r *Report) Write(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(r)
+}
+
+func ReadReport(r io.Reader) (*Report, error) {
+	report := &Report{}
+	if err := json.NewDecoder(r).Decode(report); err != nil {
+		return nil, err
+	}
+	return report, nil
+}
+
+func timevalToMs(tv syscall.Timeval) float64 {
+	return (float64(tv.Usec) / 1000.0) + float64(tv.Sec)*1000.0
+}
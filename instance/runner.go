@@ -17,45 +17,272 @@ import (
 )
 
 var (
-	ErrRealTimeLimitExceeded = defineTracerError(2, errors.New("Real time limit was exceeded"))
-	ErrMemoryLimitExceeded   = defineTracerError(3, errors.New("Memory (RSS) limit was exceeded"))
-	ErrCPUTimeLimitExceeded  = defineTracerError(4, errors.New("CPU time limit was exceeded"))
+	ErrRealTimeLimitExceeded = defineTracerError(2, VerdictTimeLimit, errors.New("Real time limit was exceeded"))
+	ErrMemoryLimitExceeded   = defineTracerError(3, VerdictMemoryLimit, errors.New("Memory (RSS) limit was exceeded"))
+	ErrCPUTimeLimitExceeded  = defineTracerError(4, VerdictCPUTimeLimit, errors.New("CPU time limit was exceeded"))
+	ErrOutputLimitExceeded   = defineTracerError(5, VerdictOutputLimit, errors.New("Output limit was exceeded"))
+	// Коды 6-8 заняты результатами проверки ответа (см. main).
+	ErrIdleLimitExceeded = defineTracerError(9, VerdictIdleLimit, errors.New("Idleness limit was exceeded"))
+)
+
+// releaseTimeout - время ожидания завершения процессов tracee после окончания трассировки.
+const releaseTimeout = time.Second
+
+// Этапы завершения tracee (Report.KillStage).
+const (
+	// KillStageSignal - tracee завершился после сигнала "--kill-signal".
+	KillStageSignal = "signal"
+	// KillStageKill - tracee завершен SIGKILL.
+	KillStageKill = "kill"
 )
 
 type traceeInstance struct {
 	process *os.Process
 	pgid    int
 
+	status syscall.WaitStatus
+	usage  syscall.Rusage
+
+	// processes - процессы дерева tracee, по которым проверяются ограничения.
+	processes *processTable
+
+	// executor - tracee запущен через executor, который устанавливает seccomp фильтр
+	// и правила Landlock перед запуском целевой программы.
+	executor bool
+	// seccomp - executor установил seccomp фильтр.
+	seccomp bool
+	// syscalls - журнал системных вызовов "--syscall-log".
+	syscalls *syscallLogger
+	// learner собирает политику в режиме "oar learn".
+	learner *policyLearner
+	// filesystem - политика доступа к файловой системе "--fs-*".
+	filesystem *fsPolicy
+	// workingDir - рабочий каталог tracee: файлы в нем мог создать сам tracee, поэтому
+	// при раскрутке стека символы в них не ищутся.
+	workingDir string
+
+	// lastPid и lastStatus - последняя остановка, полученная циклом трассировки: после выхода
+	// из цикла процесс <lastPid> остается остановленным.
+	lastPid    int
+	lastStatus syscall.WaitStatus
+
+	// killSignal и killGrace - сигнал, который tracee получает при завершении, и время
+	// до SIGKILL после него.
+	killSignal syscall.Signal
+	killGrace  time.Duration
+
+	mu sync.Mutex
+	// stage - последний этап завершения, начатый до завершения tracee.
+	stage string
+	// interrupted - момент отправки killSignal.
+	interrupted time.Time
+	// ended - tracee завершился.
+	ended bool
+
 	wg *sync.WaitGroup
 
 	stopc chan bool
 	errc  chan error
+	// done закрывается, когда заканчивается цикл трассировки.
+	done chan struct{}
 }
 
+// kill завершает tracee по причине <reason>: отправляет его процессам killSignal и, если
+// tracee не завершится за killGrace, SIGKILL.
 func (t *traceeInstance) kill(reason *TracerError) {
-	if err := t.process.Kill(); err != nil {
-		log.Debugf("[Tracee.kill] Killing error: %v\n", err)
-	}
-
-	if err := syscall.Kill(-t.pgid, syscall.SIGKILL); err != nil {
-		log.Debugf("[Tracee.kill] Killing group error: %v\n", err)
-	}
-
+	// Причина передается до завершения tracee, иначе trace может закончиться раньше
+	// и Run не получит ее.
 	select {
 	case t.errc <- reason:
 	default:
 		log.Debugf("[Tracee.kill] Reason was not sended to channel: %v\n", reason)
 	}
+
+	if deadline, ok := t.interrupt(); ok {
+		select {
+		case <-t.done:
+			// Оставшиеся процессы завершает release.
+			return
+		case <-time.After(time.Until(deadline)):
+		}
+	}
+	t.signal(syscall.SIGKILL, KillStageKill)
+}
+
+// interrupt отправляет процессам tracee killSignal (только один раз) и возвращает момент, после
+// которого их нужно завершить SIGKILL, или false, если отсрочка не задана.
+func (t *traceeInstance) interrupt() (time.Time, bool) {
+	if t.killGrace <= 0 || t.killSignal == syscall.SIGKILL {
+		return time.Time{}, false
+	}
+
+	t.mu.Lock()
+	first := t.interrupted.IsZero()
+	if first {
+		t.interrupted = time.Now()
+	}
+	deadline := t.interrupted.Add(t.killGrace)
+	t.mu.Unlock()
+
+	if first {
+		t.signal(t.killSignal, KillStageSignal)
+	}
+	return deadline, true
+}
+
+// interrupting возвращает true, если процессам tracee уже отправлен killSignal.
+func (t *traceeInstance) interrupting() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return !t.interrupted.IsZero()
+}
+
+// signal отправляет сигнал <sig> всем процессам tracee и запоминает этап завершения <stage>,
+// если tracee еще не завершился.
+func (t *traceeInstance) signal(sig syscall.Signal, stage string) {
+	t.mu.Lock()
+	if !t.ended {
+		t.stage = stage
+	}
+	t.mu.Unlock()
+	log.Debugf("[Tracee.signal] Sending %s to tracee processes (stage: %s)\n", sig, stage)
+
+	// tracer - init пространства имен PID, поэтому сигнал получат все процессы в нем,
+	// в том числе покинувшие группу tracee.
+	if os.Getpid() == 1 {
+		if err := syscall.Kill(-1, sig); err != nil {
+			log.Debugf("[Tracee.signal] Signaling namespace error: %v\n", err)
+		}
+		return
+	}
+
+	if err := t.process.Signal(sig); err != nil {
+		log.Debugf("[Tracee.signal] Signaling error: %v\n", err)
+	}
+	if err := syscall.Kill(-t.pgid, sig); err != nil {
+		log.Debugf("[Tracee.signal] Signaling group error: %v\n", err)
+	}
+}
+
+// setEnded отмечает завершение tracee: дальнейшие сигналы не меняют этап завершения.
+func (t *traceeInstance) setEnded() {
+	t.mu.Lock()
+	t.ended = true
+	t.mu.Unlock()
+}
+
+// release завершает оставшиеся процессы tracee (например, остановленные после ошибки
+// трассировки) и продолжает их ptrace остановки (PTRACE_EVENT_EXIT), пока они не завершатся.
+// Если <graceful>, процессы сначала получают killSignal и до SIGKILL ждут killGrace.
+func (t *traceeInstance) release(graceful bool) {
+	if graceful {
+		if deadline, ok := t.interrupt(); ok {
+			// Цикл трассировки мог закончиться, не продолжив остановленный процесс.
+			for _, pid := range t.processes.tasks() {
+				sig := 0
+				if pid == t.lastPid && t.lastStatus.Stopped() {
+					sig = deliveredSignal(t.lastStatus)
+				}
+				syscall.PtraceCont(pid, sig)
+			}
+			if t.reap(deadline) {
+				return
+			}
+		}
+	}
+	t.signal(syscall.SIGKILL, KillStageKill)
+	t.reap(time.Now().Add(releaseTimeout))
+}
+
+// reap продолжает ptrace остановки процессов tracee, доставляя им сигналы, и дожидается их
+// завершения до момента <deadline>. Возвращает true, если все процессы завершились.
+func (t *traceeInstance) reap(deadline time.Time) bool {
+	for time.Now().Before(deadline) {
+		var ws syscall.WaitStatus
+		var usage syscall.Rusage
+		pid, err := syscall.Wait4(-1, &ws, syscall.WALL|syscall.WNOHANG, &usage)
+		if err != nil {
+			return true
+		}
+		if pid == 0 {
+			time.Sleep(10 * time.Millisecond)
+			continue
+		}
+		t.processes.update(pid, ws, &usage)
+		if pid == t.process.Pid && (ws.Exited() || ws.Signaled()) {
+			t.status, t.usage = ws, usage
+			t.setEnded()
+		}
+		if ws.Stopped() {
+			syscall.PtraceCont(pid, deliveredSignal(ws))
+		}
+	}
+	return false
+}
+
+// syscallStop - бит, который PTRACE_O_TRACESYSGOOD добавляет к SIGTRAP при остановках
+// на системных вызовах.
+const syscallStop = 0x80
+
+// deliveredSignal возвращает сигнал, который нужно передать процессу при продолжении после
+// остановки <ws>: остановки ptrace (SIGTRAP) и SIGSTOP новых процессов не доставляются.
+func deliveredSignal(ws syscall.WaitStatus) int {
+	sig := ws.StopSignal()
+	if sig == syscall.SIGTRAP || sig == syscall.SIGTRAP|syscallStop || sig == syscall.SIGSTOP {
+		return 0
+	}
+	return int(sig)
 }
 
-func Run(processPath string, processArgs []string, cfg *Config) (int, error) {
+// isLimitError возвращает true для ошибок превышения ограничений, после которых tracee
+// завершается с отсрочкой ("--kill-grace").
+func isLimitError(err error) bool {
+	tErr, ok := err.(*TracerError)
+	if !ok {
+		return false
+	}
+	switch tErr.Verdict {
+	case VerdictTimeLimit, VerdictCPUTimeLimit, VerdictMemoryLimit, VerdictOutputLimit, VerdictIdleLimit:
+		return true
+	}
+	return false
+}
+
+func Run(processPath string, processArgs []string, cfg *Config) (int, *Report, error) {
 	runtime.LockOSThread()
 	defer runtime.UnlockOSThread()
 
+	killSignal, err := parseSignal(cfg.KillSignal)
+	if err != nil {
+		return -1, FailedReport(err), err
+	}
+
+	// Политика файловой системы проверяется ptrace'ом, чтобы вердикт указывал запрещенный путь;
+	// Landlock (если поддерживается ядром) дополнительно запрещает доступ, который проверка
+	// пропустила бы из-за подмены пути между проверкой и вызовом.
+	filesystem, err := cfg.filesystemPolicy()
+	if err != nil {
+		return -1, FailedReport(err), err
+	}
+	ruleset, err := cfg.landlockRuleset(filesystem, processPath)
+	if err != nil {
+		return -1, FailedReport(err), err
+	}
+
+	workingDir, err := filepath.Abs(cfg.WorkingDir)
+	if err != nil {
+		return -1, FailedReport(err), err
+	}
+
 	tracee := &traceeInstance{
-		stopc: make(chan bool),
-		errc:  make(chan error, 1),
-		wg:    &sync.WaitGroup{},
+		filesystem: filesystem,
+		workingDir: resolveSymlinks(workingDir),
+		killSignal: killSignal,
+		killGrace:  time.Duration(cfg.KillGrace) * time.Millisecond,
+		stopc:      make(chan bool),
+		errc:       make(chan error, 1),
+		done:       make(chan struct{}),
+		wg:         &sync.WaitGroup{},
 	}
 	// defer close(tracee.stopc)
 
@@ -64,34 +291,70 @@ func Run(processPath string, processArgs []string, cfg *Config) (int, error) {
 
 	processArgs = append([]string{processName}, processArgs...)
 
-	ptrace := !cfg.AllowCreateProcesses || !cfg.AllowMultiThreading
-	log.Debugf("Ptrace - %t [Allow create processes - %t] [Allow multithreading - %t]", ptrace, cfg.AllowCreateProcesses, cfg.AllowMultiThreading)
+	log.Debugf("Allow create processes - %t, allow multithreading - %t", cfg.AllowCreateProcesses, cfg.AllowMultiThreading)
 
-	// inReader, inWriter, err := os.Pipe()
-	// if err != nil {
-	// 	return -1, err
-	// }
-	// defer inWriter.Close()
+	files := cfg.stdio()
 
-	// outReader, outWriter, err := os.Pipe()
-	// if err != nil {
-	// 	return -1, err
-	// }
-	// defer outReader.Close()
+	var relays []*outputRelay
+	relaysStarted := false
+	// fail освобождает потоки tracee и пересылки его вывода, если запуск не удался.
+	fail := func(err error) (int, *Report, error) {
+		if relaysStarted {
+			waitOutputRelays(relays)
+		} else {
+			closeOutputRelays(relays)
+			closeStdio(cfg.Stdio)
+		}
+		return -1, FailedReport(err), err
+	}
 
-	// errReader, errWriter, err := os.Pipe()
-	// if err != nil {
-	// 	return -1, err
-	// }
-	// defer errReader.Close()
+	if cfg.OutputLimit > 0 {
+		var err error
+		files, relays, err = prepareOutputRelays(files, cfg.OutputLimit*1024)
+		if err != nil {
+			return fail(err)
+		}
+	}
 
-	// go io.Copy(inWriter, os.Stdin)
-	// go io.Copy(os.Stdout, outReader)
-	// go io.Copy(os.Stderr, errReader)
+	// Файлы учитываются только в явно указанном рабочем каталоге: обход всей корневой ФС
+	// был бы слишком долгим.
+	var output *dirOutput
+	if cfg.OutputLimit > 0 && len(cfg.WorkingDir) > 0 {
+		if output, err = newDirOutput(workingDir); err != nil {
+			return fail(err)
+		}
+	}
 
-	files := []*os.File{os.Stdin, os.Stdout, os.Stderr}
+	var program []unix.SockFilter
+	if filter := cfg.syscallFilter(); filter != nil {
+		if program, err = filter.Compile(); err != nil {
+			return fail(err)
+		}
+		tracee.seccomp = true
+		log.Debugf("Seccomp filter is enabled (%d instructions, action: %s)\n", len(program), cfg.SeccompAction)
+	}
 
-	process, err := os.StartProcess(processPath, processArgs, &os.ProcAttr{
+	startPath, startArgs := processPath, processArgs
+	if program != nil || ruleset != nil {
+		var executorFiles []*os.File
+		startPath, startArgs, executorFiles, err = prepareExecutor(program, ruleset, processPath, processArgs)
+		if err != nil {
+			return fail(err)
+		}
+		defer func() {
+			for _, f := range executorFiles {
+				if f != nil {
+					f.Close()
+				}
+			}
+		}()
+
+		files = append(files, executorFiles...)
+		tracee.executor = true
+	}
+
+	started := time.Now()
+	process, err := os.StartProcess(startPath, startArgs, &os.ProcAttr{
 		Files: files,
 		Dir:   cfg.WorkingDir,
 		Env:   append([]string{}, cfg.Env...),
@@ -102,59 +365,120 @@ func Run(processPath string, processArgs []string, cfg *Config) (int, error) {
 		},
 	})
 	if err != nil {
-		return -1, err
+		return fail(err)
 	}
 
 	tracee.process = process
+	tracee.processes = newProcessTable(process.Pid)
+
+	// abort завершает tracee, не успевший начать работу, и дожидается его, чтобы следующий
+	// запуск в этом же tracer'е не получил его статус от wait4.
+	abort := func(err error) (int, *Report, error) {
+		process.Kill()
+		var ws syscall.WaitStatus
+		syscall.Wait4(process.Pid, &ws, syscall.WALL, nil)
+		return fail(err)
+	}
+
+	if cfg.OutputLimit > 0 {
+		if err = system.SetProcessRlimit(process.Pid, unix.RLIMIT_FSIZE, uint64(cfg.OutputLimit*1024)); err != nil {
+			return abort(err)
+		}
+		log.Debugf("Tracee file size limit was set to %dKB\n", cfg.OutputLimit)
+	}
+
+	if cfg.CgroupProcs != nil {
+		if err = system.AttachToCgroup(cfg.CgroupProcs, process.Pid); err != nil {
+			return abort(err)
+		}
+		log.Debugf("Tracee is attached to the cgroup\n")
+	}
+
+	for _, relay := range relays {
+		relay.start(tracee)
+	}
+	relaysStarted = true
 
-	// for _, fd := range files {
-	// 	if err = fd.Close(); err != nil {
-	// 		return -1, err
-	// 	}
-	// }
+	closeStdio(cfg.Stdio)
 
 	pid := process.Pid
 	pgid, err := syscall.Getpgid(pid)
 	if err != nil {
-		return -1, err
+		return abort(err)
 	}
 	tracee.pgid = pgid
 	log.Debugf("Tracee pgid is: %d\n", tracee.pgid)
 
 	if err = setAffinity(pid, cfg); err != nil {
-		return -1, err
+		return abort(err)
 	}
 
-	if cfg.RealTimeLimit > 0 {
-		go startKillingTimer(tracee, cfg)
-	}
-
-	if cfg.CPUTimeLimit > 0 || cfg.MemoryLimit > 0 {
-		go startCheckingLimits(tracee, cfg)
-	}
-
-	_, status, err := wait(pid)
+	_, status, err := wait(pid, &tracee.usage)
 	if err != nil {
-		return -1, err
+		return abort(err)
 	}
+	tracee.status = status
+	tracee.processes.update(pid, status, &tracee.usage)
 
 	switch {
 	case status.Exited():
-		return status.ExitStatus(), nil
+		waitOutputRelays(relays)
+		report := newReport(started, status, &tracee.usage, nil)
+		report.CPUTime, _ = tracee.processes.cpuTime()
+		report.Processes = tracee.processes.list()
+		return status.ExitStatus(), report, nil
 	case status.Stopped():
+		// tracee всегда запускается под ptrace: таблица процессов и ограничения дерева
+		// процессов требуют событий ptrace даже без проверки создания процессов.
 		signal := status.StopSignal()
-		if !ptrace {
-			return -1, fmt.Errorf("Wait status of tracee is \"Stopped\" (%s), but ptrace is disabled", signal.String())
-		}
 		if signal != syscall.SIGTRAP {
-			return -1, err
+			return abort(fmt.Errorf("Unexpected stop signal %v of tracee", signal))
 		}
 		log.Debugf("[PID %d] Status is \"Stopped\" (SIGTRAP: %s)", pid, signal.String())
 	default:
-		return -1, err
+		return abort(fmt.Errorf("Unexpected status of tracee: %v", status.Signal()))
+	}
+
+	if cfg.RealTimeLimit > 0 {
+		go startKillingTimer(tracee, cfg)
+	}
+
+	if cfg.CPUTimeLimit > 0 {
+		go startLimitingCPU(tracee, cfg)
+	}
+
+	if cfg.CPUTimeLimit > 0 || cfg.MemoryLimit > 0 || cfg.IdleLimit > 0 {
+		go startCheckingLimits(tracee, cfg)
+	}
+
+	if cfg.StatsFile != nil {
+		go startCollectingStats(tracee, cfg, started)
+	}
+
+	if output != nil {
+		go startCheckingOutput(tracee, output, cfg.OutputLimit*1024)
+	}
+
+	if cfg.SyscallLogFile != nil {
+		tracee.syscalls = newSyscallLogger(cfg.SyscallLogFile, started)
+	}
+	if cfg.LearnPolicy {
+		tracee.learner = newPolicyLearner()
 	}
 
 	exitCode, tErr := trace(tracee, cfg)
+	close(tracee.done)
+
+	// Остановленные процессы tracee удерживают pipe'ы открытыми до выхода tracer'а
+	// и получили бы события ptrace следующего запуска в этом же tracer'е.
+	tracee.release(tracee.interrupting() || isLimitError(tErr))
+	waitOutputRelays(relays)
+
+	if tracee.syscalls != nil {
+		if err := tracee.syscalls.close(); err != nil {
+			log.Warnf("Unable to write syscall log: %v\n", err)
+		}
+	}
 
 	select {
 	case tErr = <-tracee.errc:
@@ -162,6 +486,11 @@ func Run(processPath string, processArgs []string, cfg *Config) (int, error) {
 	default:
 	}
 
+	// Файлы, записанные после последней проверки, учитываются после завершения tracee.
+	if tErr == nil && output != nil && output.written() > cfg.OutputLimit*1024 {
+		tErr = outputLimitError("Run", OutputFile)
+	}
+
 	if tErr == nil {
 		if !cfg.PropagateExitCode {
 			exitCode = 0
@@ -180,7 +509,17 @@ func Run(processPath string, processArgs []string, cfg *Config) (int, error) {
 	close(tracee.stopc)
 	tracee.wg.Wait()
 
-	return exitCode, tErr
+	report := newReport(started, tracee.status, &tracee.usage, tErr)
+	report.KillStage = tracee.stage
+	report.Landlock = ruleset != nil
+	report.CPUTime, _ = tracee.processes.cpuTime()
+	report.Processes = tracee.processes.list()
+	if tracee.learner != nil {
+		report.LearnedPolicy = tracee.learner.policy()
+	}
+	log.Debugf("Tracee report: %+v\n", report)
+
+	return exitCode, report, tErr
 }
 
 func startCheckingLimits(tracee *traceeInstance, cfg *Config) {
@@ -190,22 +529,32 @@ func startCheckingLimits(tracee *traceeInstance, cfg *Config) {
 	log.Debugln("Goroutine \"startCheckingLimits\" started")
 	defer log.Debugln("Goroutine \"startCheckingLimits\" terminated")
 
+	idle := newIdleMeter(cfg)
+
 	ticker := time.NewTicker(500 * time.Millisecond)
 	for {
 		select {
 		case <-tracee.stopc:
 			return
 		case <-ticker.C:
-			time, _, err := system.GetProcessStats(tracee.process.Pid)
+			cpu, memory, err := tracee.processes.procfsUsage(cfg.MemoryAccounting)
 			if err != nil {
-				tErr := createTracerError("startCheckingLimits [system.GetProcessStats]", err)
+				tErr := createTracerError("startCheckingLimits [processTable.procfsUsage]", err)
 				tracee.kill(tErr)
 				return
 			}
 
+			if cfg.IdleLimit > 0 {
+				if err = idle.update(cpu); err != nil {
+					tErr := createTracerError("startCheckingLimits", err)
+					tracee.kill(tErr)
+					return
+				}
+			}
+
 			cpuLim := cfg.CPUTimeLimit
 			if cpuLim >= 0 {
-				_, err = checkCPUTimeLimit(float64(time), cpuLim, -1)
+				_, err = checkCPUTimeLimit(cpu, cpuLim, -1)
 				if err != nil {
 					tErr := createTracerError("startCheckingLimits", err)
 					tracee.kill(tErr)
@@ -213,16 +562,9 @@ func startCheckingLimits(tracee *traceeInstance, cfg *Config) {
 				}
 			}
 
-			memory, err := system.GetProcessMemoryPeak(tracee.process.Pid)
-			if err != nil {
-				tErr := createTracerError("startCheckingLimits [system.GetProcessMemoryPeak]", err)
-				tracee.kill(tErr)
-				return
-			}
-
 			memLim := cfg.MemoryLimit
 			if memLim >= 0 {
-				err = checkMemoryLimit(int64(memory), memLim)
+				err = checkMemoryLimit(memory, memLim)
 				if err != nil {
 					tErr := createTracerError("startCheckingLimits", err)
 					tracee.kill(tErr)
@@ -303,6 +645,26 @@ func trace(tracee *traceeInstance, cfg *Config) (int, error) {
 	options |= syscall.PTRACE_O_TRACEVFORKDONE
 	options |= syscall.PTRACE_O_TRACEEXEC
 	options |= syscall.PTRACE_O_TRACEEXIT
+	options |= syscall.PTRACE_O_TRACESYSGOOD
+
+	// С seccomp фильтром tracee останавливается только на отмеченных фильтром вызовах,
+	// поэтому остановки на каждом системном вызове не нужны.
+	resume := syscall.PtraceSyscall
+	if tracee.seccomp {
+		options |= unix.PTRACE_O_TRACESECCOMP
+		resume = syscall.PtraceCont
+	}
+	if tracee.syscalls != nil || tracee.learner != nil || tracee.filesystem != nil {
+		resume = syscall.PtraceSyscall
+	}
+
+	// Пока executor не запустил целевую программу, его потоки и exec не являются нарушениями.
+	executing := tracee.executor
+	lastSyscalls := make(map[int]int)
+	// faults - последний доставленный каждому процессу сигнал.
+	faults := make(map[int]*RuntimeError)
+	// inSyscall - процессы, остановленные на входе в системный вызов, до остановки на выходе.
+	inSyscall := make(map[int]bool)
 
 	formatError := func(culprit string, err error) (int, error) {
 		currentCommand := processCommandName(currentPid, traceePid)
@@ -311,6 +673,33 @@ func trace(tracee *traceeInstance, cfg *Config) (int, error) {
 		return -1, fmt.Errorf("%d | Error at level %d [%s] for [PID: %d (%s), Prev. PID: %d (%s)]: %v", iterations, level, culprit, currentPid, currentCommand, previousPid, previousCommand, err)
 	}
 
+	signalError := func(culprit string, fault *RuntimeError) (int, error) {
+		_, err := formatError(culprit, fault)
+		tErr := createRuntimeError(culprit, err)
+		tErr.Runtime = fault
+		return -1, tErr
+	}
+
+	violationError := func(culprit string, err error) (int, error) {
+		_, err = formatError(culprit, err)
+		return -1, createViolationError(culprit, err)
+	}
+
+	syscallError := func(culprit string, nr int) (int, error) {
+		name := system.SyscallName(nr)
+		_, err := formatError(culprit, fmt.Errorf("Syscall \"%s\" (%d) is not allowed", name, nr))
+		tErr := createViolationError(culprit, err)
+		tErr.Syscall = name
+		return -1, tErr
+	}
+
+	pathError := func(culprit string, violation *fsViolation) (int, error) {
+		_, err := formatError(culprit, violation)
+		tErr := createViolationError(culprit, err)
+		tErr.Syscall, tErr.Path, tErr.Access = violation.syscall, violation.path, violation.access
+		return -1, tErr
+	}
+
 	debugStatus := func(pid int, status syscall.WaitStatus) {
 		if !cfg.Debug {
 			return
@@ -333,6 +722,70 @@ func trace(tracee *traceeInstance, cfg *Config) (int, error) {
 		}
 	}
 
+	// spawned добавляет в таблицу процессов потомка, о создании которого сообщает событие ptrace.
+	// PTRACE_EVENT_CLONE считается созданием потока: процессы создаются через fork и vfork.
+	spawned := func(thread bool) error {
+		child, err := syscall.PtraceGetEventMsg(currentPid)
+		if err != nil {
+			return err
+		}
+		tracee.processes.spawned(currentPid, int(child), thread)
+		if tracee.learner != nil {
+			tracee.learner.spawned(thread)
+		}
+		return nil
+	}
+
+	// executed учитывает замену программы процессом (PTRACE_EVENT_EXEC) и проверяет, что
+	// политика разрешает запуск нового исполняемого файла.
+	executed := func() error {
+		tracee.processes.exec(currentPid)
+		restricted := cfg.Policy != nil && len(cfg.Policy.Processes.ExecPaths) > 0
+		if tracee.learner == nil && !restricted {
+			return nil
+		}
+
+		procfsPid, err := tracee.processes.procfs(currentPid)
+		if err != nil {
+			return err
+		}
+		path, err := system.GetProcessExecutable(procfsPid)
+		if err != nil {
+			return err
+		}
+		if tracee.learner != nil {
+			tracee.learner.executed(path)
+		}
+		if !cfg.Policy.execAllowed(path) {
+			return fmt.Errorf("Executing \"%s\" is not allowed by policy", path)
+		}
+		return nil
+	}
+
+	// checkPaths проверяет по политике файловой системы пути системного вызова, на входе
+	// в который остановлен текущий процесс.
+	checkPaths := func() (*fsViolation, error) {
+		state, err := system.GetSyscallState(currentPid)
+		if err != nil {
+			return nil, err
+		}
+		entry := !inSyscall[currentPid] && state.Result == -int64(syscall.ENOSYS)
+		if !entry {
+			delete(inSyscall, currentPid)
+			return nil, nil
+		}
+		inSyscall[currentPid] = true
+		if state.Nr < 0 {
+			return nil, nil
+		}
+
+		procfsPid, err := tracee.processes.procfs(currentPid)
+		if err != nil {
+			return nil, err
+		}
+		return tracee.filesystem.check(currentPid, procfsPid, state)
+	}
+
 	debugMessage := func(msg string, a ...interface{}) {
 		if !cfg.Debug {
 			return
@@ -348,7 +801,7 @@ func trace(tracee *traceeInstance, cfg *Config) (int, error) {
 		return formatError("syscall.PtraceSetOptions (before loop)", err)
 	}
 
-	err = syscall.PtraceSyscall(currentPid, 0)
+	err = resume(currentPid, 0)
 	if err != nil {
 		return formatError("syscall.PtraceCont (before loop)", err)
 	}
@@ -372,9 +825,28 @@ func trace(tracee *traceeInstance, cfg *Config) (int, error) {
 			return formatError("syscall.Wait4", err)
 		}
 
+		tracee.lastPid, tracee.lastStatus = waitPid, ws
+		if waitPid == traceePid {
+			tracee.status = ws
+			tracee.usage = usage
+			if ws.Exited() || ws.Signaled() {
+				tracee.setEnded()
+			}
+		}
+
+		if waitPid > 0 {
+			tracee.processes.update(waitPid, ws, &usage)
+		}
+
+		// Ограничения применяются к сумме по всему дереву процессов, а не к процессу,
+		// который вернул wait4.
+		cpu, memory := tracee.processes.usage()
+
+		// wait4 сообщает только пиковый размер резидентной памяти, остальные способы учета
+		// проверяются по /proc в startCheckingLimits.
 		memLim := cfg.MemoryLimit
-		if memLim >= 0 {
-			err = checkMemoryLimit(usage.Maxrss, memLim)
+		if memLim >= 0 && cfg.MemoryAccounting == MemoryHWM {
+			err = checkMemoryLimit(memory, memLim)
 			if err != nil {
 				return -1, err
 			}
@@ -382,15 +854,7 @@ func trace(tracee *traceeInstance, cfg *Config) (int, error) {
 
 		cpuLim := cfg.CPUTimeLimit
 		if cpuLim >= 0 {
-			utime := usage.Utime
-			ums := (float64(utime.Usec) / 1000.0) + float64(utime.Sec)*1000.0
-
-			stime := usage.Stime
-			sms := (float64(stime.Usec) / 1000.0) + float64(stime.Sec)*1000.0
-
-			total := sms + ums
-
-			prevCPUPerc, err = checkCPUTimeLimit(total, cpuLim, prevCPUPerc)
+			prevCPUPerc, err = checkCPUTimeLimit(cpu, cpuLim, prevCPUPerc)
 			if err != nil {
 				return -1, err
 			}
@@ -413,27 +877,91 @@ func trace(tracee *traceeInstance, cfg *Config) (int, error) {
 
 		exited := ws.Exited()
 		signaled := ws.Signaled()
+		if signaled && ws.Signal() == syscall.SIGSYS && tracee.seccomp {
+			if nr, ok := lastSyscalls[currentPid]; ok {
+				return syscallError("Killed by seccomp filter", nr)
+			}
+			return violationError("Killed by seccomp filter", errors.New("Signal: SIGSYS"))
+		}
+		if (exited || signaled) && tracee.syscalls != nil && !executing {
+			tracee.syscalls.exited(currentPid, ws)
+		}
 		if exited || signaled {
+			fault := faults[currentPid]
+			delete(faults, currentPid)
+			delete(inSyscall, currentPid)
+			if signaled {
+				if fault == nil || fault.Signal != int(ws.Signal()) {
+					fault = newRuntimeError(currentPid, ws.Signal())
+				}
+				fault.terminated(ws)
+			}
+
 			if currentPid == traceePid {
 				debugMessage("Before loop exit, tracee status [exited: %t] [signaled: %t]", exited, signaled)
 				if exited {
 					return ws.ExitStatus(), nil
 				}
-				err = fmt.Errorf("Signal: %s", ws.Signal().String())
-				return formatError("Tracee signaled", err)
+				return signalError("Tracee signaled", fault)
+			}
+			if signaled && handleableSignal(ws.Signal()) {
+				return signalError("Child process signaled", fault)
 			}
 			debugMessage("Child process %d exited", currentPid)
 			continue
 		}
 
+		if ws.Stopped() && ws.StopSignal() == syscall.SIGTRAP|syscallStop {
+			// Остановка на входе в системный вызов или выходе из него (PTRACE_O_TRACESYSGOOD).
+			// Вызовы executor'а до запуска целевой программы не записываются.
+			if tracee.syscalls != nil && !executing {
+				if err = tracee.syscalls.stop(currentPid); err != nil {
+					debugMessage("Unable to log syscall: %v", err)
+				}
+			}
+			if tracee.learner != nil && !executing {
+				if err = tracee.learner.syscall(currentPid); err != nil {
+					debugMessage("Unable to learn syscall: %v", err)
+				}
+			}
+			if tracee.filesystem != nil && !executing {
+				violation, err := checkPaths()
+				if err != nil {
+					return formatError("Filesystem policy", err)
+				}
+				if violation != nil {
+					return pathError("Filesystem policy", violation)
+				}
+			}
+			if err = resume(currentPid, 0); err != nil && err != syscall.ESRCH {
+				return formatError("syscall.PtraceSyscall", err)
+			}
+			continue
+		}
+
 		if ws.Stopped() {
 			switch ws.StopSignal() {
 			case syscall.SIGXCPU:
-				err = errors.New("CPU time limit exceeded")
-				return formatError("syscall.SIGXCPU", err)
-			case syscall.SIGSEGV:
-				err = errors.New("Segmentation fault (memory access violation)")
-				return formatError("syscall.SIGSEGV", err)
+				return -1, createTracerError("syscall.SIGXCPU", ErrCPUTimeLimitExceeded)
+			case syscall.SIGXFSZ:
+				return -1, outputLimitError("syscall.SIGXFSZ", OutputFile)
+			default:
+				// Сведения о сигнале доступны только в момент его доставки, поэтому сохраняются
+				// до завершения процесса.
+				if sig := syscall.Signal(deliveredSignal(ws)); sig != 0 {
+					fault := inspectSignal(tracee.processes, currentPid, sig)
+					debugMessage("Signal delivery: %v", fault)
+					if tracee.syscalls != nil && !executing {
+						tracee.syscalls.signal(currentPid, fault)
+					}
+					if handleableSignal(sig) && (!fault.Handled || !cfg.AllowSignalHandlers) {
+						fault.unwind(tracee.processes, currentPid, tracee.workingDir)
+					}
+					if fault.Handled && !cfg.AllowSignalHandlers {
+						return signalError("Signal handler", fault)
+					}
+					faults[currentPid] = fault
+				}
 			}
 
 			trap := ws.TrapCause()
@@ -441,15 +969,49 @@ func trace(tracee *traceeInstance, cfg *Config) (int, error) {
 				culprit := "Trap Cause: PTRACE_EVENT_CLONE"
 				debugMessage("%s (%d)", culprit, trap)
 
-				if !cfg.AllowMultiThreading {
+				if !cfg.AllowMultiThreading && !executing {
 					err = errors.New("Cloning processes is not allowed")
-					return formatError(culprit, err)
+					return violationError(culprit, err)
+				}
+				if err = spawned(true); err != nil {
+					return formatError("syscall.PtraceGetEventMsg", err)
 				}
 			} else if trap == syscall.PTRACE_EVENT_VFORK_DONE {
 				debugMessage("Trap Cause: PTRACE_EVENT_VFORK_DONE (%d)", trap)
 			} else if trap == syscall.PTRACE_EVENT_EXIT {
 				debugMessage("Trap Cause: PTRACE_EVENT_EXIT (%d)", trap)
 				level--
+
+				if tracee.seccomp {
+					if nr, err := system.GetSyscallNumber(currentPid); err == nil {
+						lastSyscalls[currentPid] = nr
+					}
+				}
+			} else if trap == unix.PTRACE_EVENT_SECCOMP && executing {
+				// Фильтр установлен executor'ом до exec целевой программы: вызовы среды
+				// выполнения Go и самого exec нарушениями не являются.
+				debugMessage("Trap Cause: PTRACE_EVENT_SECCOMP (%d), executor has not started the tracee yet", trap)
+			} else if trap == unix.PTRACE_EVENT_SECCOMP {
+				nr, err := system.GetSyscallNumber(currentPid)
+				if err != nil {
+					return formatError("system.GetSyscallNumber", err)
+				}
+				culprit := "Trap Cause: PTRACE_EVENT_SECCOMP"
+				debugMessage("%s (%d): %s", culprit, trap, system.SyscallName(nr))
+
+				return syscallError(culprit, nr)
+			} else if trap == syscall.PTRACE_EVENT_EXEC && executing && currentPid == traceePid {
+				debugMessage("Trap Cause: PTRACE_EVENT_EXEC (%d), executor started the tracee", trap)
+				executing = false
+				if tracee.syscalls != nil {
+					tracee.syscalls.skip(currentPid)
+				}
+				tracee.processes.exec(currentPid)
+			} else if trap == syscall.PTRACE_EVENT_EXEC && cfg.AllowExec {
+				debugMessage("Trap Cause: PTRACE_EVENT_EXEC (%d), exec is allowed", trap)
+				if err = executed(); err != nil {
+					return violationError("Trap Cause: PTRACE_EVENT_EXEC", err)
+				}
 			} else {
 				var trapName string
 				switch trap {
@@ -469,7 +1031,14 @@ func trace(tracee *traceeInstance, cfg *Config) (int, error) {
 
 					if !cfg.AllowCreateProcesses {
 						err = errors.New("Spawning child processes is not allowed")
-						return formatError(culprit, err)
+						return violationError(culprit, err)
+					}
+					if trap == syscall.PTRACE_EVENT_EXEC {
+						if err = executed(); err != nil {
+							return violationError(culprit, err)
+						}
+					} else if err = spawned(false); err != nil {
+						return formatError("syscall.PtraceGetEventMsg", err)
 					}
 				}
 			}
@@ -482,8 +1051,10 @@ func trace(tracee *traceeInstance, cfg *Config) (int, error) {
 		// 	return formatError("syscall.PtraceSetOptions", err)
 		// }
 
-		err = syscall.PtraceSyscall(currentPid, 0)
-		if err != nil {
+		// Остановленный поток может быть завершен до продолжения (SIGKILL, exec другого
+		// потока процесса), о его завершении сообщит wait4.
+		err = resume(currentPid, deliveredSignal(ws))
+		if err != nil && err != syscall.ESRCH {
 			return formatError("syscall.PtraceCont", err)
 		}
 	}
@@ -0,0 +1,249 @@
+package instance
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"strings"
+	"syscall"
+
+	"github.com/docker/docker/pkg/reexec"
+	log "github.com/sirupsen/logrus"
+	"github.com/solovev/orange-app-runner/system"
+	"golang.org/x/sys/unix"
+)
+
+// executor - имя, под которым tracer перезапускает себя в качестве tracee,
+// чтобы установить seccomp фильтр и правила Landlock перед запуском целевой программы.
+const executor = "ejudge_executor"
+
+// Дескрипторы, через которые executor получает BPF программу и правила Landlock.
+const (
+	executorFilterFd   = 3
+	executorLandlockFd = 4
+)
+
+const (
+	ActionKill  = "KILL"
+	ActionErrno = "ERRNO"
+	ActionTrace = "TRACE"
+)
+
+func init() {
+	reexec.Register(executor, startExecutor)
+}
+
+// SyscallFilter описывает seccomp фильтр в виде списков разрешенных и запрещенных системных вызовов.
+// Если список разрешенных вызовов не пуст, то действие <Action> применяется ко всем вызовам не из этого списка,
+// иначе - только к вызовам из списка запрещенных. Вызовы с условиями на аргументы <Arguments>
+// разрешены только при выполнении этих условий.
+type SyscallFilter struct {
+	Allow     []string
+	Deny      []string
+	Arguments []ArgumentRule
+	Action    string
+}
+
+// syscallFilter объединяет правила политики и параметров командной строки.
+// Действие, указанное в политике, имеет приоритет над "--seccomp-action".
+func (cfg *Config) syscallFilter() *SyscallFilter {
+	filter := &SyscallFilter{
+		Allow:  append([]string{}, cfg.SeccompAllow...),
+		Deny:   append([]string{}, cfg.SeccompDeny...),
+		Action: cfg.SeccompAction,
+	}
+
+	if policy := cfg.Policy; policy != nil {
+		filter.Allow = append(filter.Allow, policy.Syscalls.Allow...)
+		filter.Deny = append(filter.Deny, policy.Syscalls.Deny...)
+		filter.Arguments = append(filter.Arguments, policy.Arguments...)
+		if len(policy.Action) > 0 {
+			filter.Action = policy.Action
+		}
+	}
+
+	if len(filter.Allow) == 0 && len(filter.Deny) == 0 && len(filter.Arguments) == 0 {
+		return nil
+	}
+	return filter
+}
+
+// Compile собирает BPF программу фильтра. Вызов "execve" разрешается всегда, т.к. через него
+// executor запускает целевую программу, повторный запуск программ контролируется ptrace'ом.
+func (f *SyscallFilter) Compile() ([]unix.SockFilter, error) {
+	action, err := seccompAction(f.Action)
+	if err != nil {
+		return nil, err
+	}
+
+	execve, _ := system.SyscallNumber("execve")
+	rules := []system.SeccompRule{{Syscall: execve, Action: system.SeccompAllow}}
+
+	for _, name := range f.Deny {
+		nr, ok := system.SyscallNumber(name)
+		if !ok {
+			return nil, fmt.Errorf("Unknown syscall \"%s\"", name)
+		}
+		if nr == execve {
+			return nil, fmt.Errorf("Syscall \"%s\" can not be denied, use process rules instead", name)
+		}
+		rules = append(rules, system.SeccompRule{Syscall: nr, Action: action})
+	}
+
+	var conditional []int
+	args := make(map[int][]system.SeccompArg)
+	for _, arg := range f.Arguments {
+		nr, ok := system.SyscallNumber(arg.Syscall)
+		if !ok {
+			return nil, fmt.Errorf("Unknown syscall \"%s\"", arg.Syscall)
+		}
+		if _, ok := args[nr]; !ok {
+			conditional = append(conditional, nr)
+		}
+		args[nr] = append(args[nr], system.SeccompArg{
+			Index: arg.Arg,
+			Op:    arg.Op,
+			Mask:  arg.Mask,
+			Value: arg.Value,
+		})
+	}
+	for _, nr := range conditional {
+		rules = append(rules, system.SeccompRule{
+			Syscall:   nr,
+			Action:    system.SeccompAllow,
+			Args:      args[nr],
+			Otherwise: action,
+		})
+	}
+
+	for _, name := range f.Allow {
+		nr, ok := system.SyscallNumber(name)
+		if !ok {
+			return nil, fmt.Errorf("Unknown syscall \"%s\"", name)
+		}
+		rules = append(rules, system.SeccompRule{Syscall: nr, Action: system.SeccompAllow})
+	}
+
+	defaultAction := system.SeccompAllow
+	if len(f.Allow) > 0 {
+		defaultAction = action
+	}
+	return system.BuildSeccompFilter(rules, defaultAction)
+}
+
+func seccompAction(name string) (uint32, error) {
+	switch strings.ToUpper(name) {
+	case ActionKill:
+		return system.SeccompKill, nil
+	case ActionErrno:
+		return system.SeccompErrnoAction(syscall.EPERM), nil
+	case ActionTrace, "":
+		return system.SeccompTrace, nil
+	}
+	return 0, fmt.Errorf("Unknown seccomp action \"%s\"", name)
+}
+
+// startExecutor применяет правила Landlock и устанавливает seccomp фильтр (то, что передал
+// tracer) и замещает текущий процесс целевой программой.
+// Аргументы: executor [--landlock] [--seccomp] -- <путь к программе> <argv[0]> [<параметры>].
+func startExecutor() {
+	runtime.LockOSThread()
+
+	var landlock, seccomp bool
+	args := os.Args[1:]
+	for ; len(args) > 0 && args[0] != "--"; args = args[1:] {
+		switch args[0] {
+		case "--landlock":
+			landlock = true
+		case "--seccomp":
+			seccomp = true
+		default:
+			log.Fatalf("Executor: unknown option \"%s\"\n", args[0])
+		}
+	}
+	if len(args) < 3 {
+		log.Fatalf("Executor: not enough arguments: %v\n", os.Args)
+	}
+	args = args[1:]
+
+	// Правила Landlock применяются первыми: seccomp фильтр может запрещать их вызовы.
+	if landlock {
+		f := os.NewFile(executorLandlockFd, "landlock")
+		ruleset, err := readLandlockRuleset(f)
+		f.Close()
+		if err != nil {
+			log.Fatalf("Executor: unable to read Landlock ruleset: %v\n", err)
+		}
+		if err := ruleset.restrict(); err != nil {
+			log.Fatalf("Executor: %v\n", err)
+		}
+	}
+
+	if seccomp {
+		f := os.NewFile(executorFilterFd, "seccomp")
+		filter, err := system.ReadSeccompFilter(f)
+		f.Close()
+		if err != nil {
+			log.Fatalf("Executor: unable to read seccomp filter: %v\n", err)
+		}
+
+		if err := system.InstallSeccompFilter(filter); err != nil {
+			log.Fatalf("Executor: %v\n", err)
+		}
+	}
+
+	err := syscall.Exec(args[0], args[1:], os.Environ())
+	log.Fatalf("Executor: unable to execute \"%s\": %v\n", args[0], err)
+}
+
+// prepareExecutor передает executor'у BPF программу <filter> и правила Landlock <ruleset>
+// (любое из них может отсутствовать) через pipe'ы и возвращает путь и аргументы для его
+// запуска и дескрипторы executorFilterFd и executorLandlockFd (nil, если не используются).
+func prepareExecutor(filter []unix.SockFilter, ruleset *landlockRuleset, processPath string, processArgs []string) (string, []string, []*os.File, error) {
+	files := make([]*os.File, executorLandlockFd-executorFilterFd+1)
+	args := []string{executor}
+
+	if ruleset != nil {
+		r, err := writeToPipe(ruleset.write)
+		if err != nil {
+			return "", nil, nil, err
+		}
+		files[executorLandlockFd-executorFilterFd] = r
+		args = append(args, "--landlock")
+	}
+
+	if filter != nil {
+		r, err := writeToPipe(func(w io.Writer) error {
+			return system.WriteSeccompFilter(w, filter)
+		})
+		if err != nil {
+			if files[executorLandlockFd-executorFilterFd] != nil {
+				files[executorLandlockFd-executorFilterFd].Close()
+			}
+			return "", nil, nil, err
+		}
+		files[0] = r
+		args = append(args, "--seccomp")
+	}
+
+	args = append(append(args, "--", processPath), processArgs...)
+	return reexec.Self(), args, files, nil
+}
+
+// writeToPipe создает pipe, записывает в него данные функцией <write> и возвращает его конец
+// для чтения. Данные должны помещаться в буфер pipe'а.
+func writeToPipe(write func(w io.Writer) error) (*os.File, error) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+
+	err = write(w)
+	w.Close()
+	if err != nil {
+		r.Close()
+		return nil, err
+	}
+	return r, nil
+}
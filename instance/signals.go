@@ -0,0 +1,164 @@
+package instance
+
+import (
+	"fmt"
+	"syscall"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/solovev/orange-app-runner/system"
+)
+
+// stackGuardGap - расстояние от начала стека, в пределах которого ошибка доступа к памяти
+// считается переполнением стека (stack_guard_gap ядра по умолчанию - 256 страниц).
+const stackGuardGap = 1 << 20
+
+// RuntimeError - классифицированная ошибка выполнения: сигнал, завершивший процесс tracee.
+type RuntimeError struct {
+	PID        int    `json:"pid"`
+	Signal     int    `json:"signal"`
+	SignalName string `json:"signal_name"`
+	// Code и CodeName - значение si_code (причина сигнала), если его удалось получить.
+	Code     int32  `json:"code"`
+	CodeName string `json:"code_name,omitempty"`
+	// Address - адрес, вызвавший ошибку (SIGSEGV, SIGBUS, SIGFPE, SIGILL).
+	Address     string `json:"address,omitempty"`
+	Description string `json:"description"`
+	// StackOverflow - адрес ошибки находится под стеком основного потока.
+	StackOverflow bool `json:"stack_overflow,omitempty"`
+	// Handled - у процесса был установлен обработчик сигнала.
+	Handled    bool `json:"handled,omitempty"`
+	CoreDumped bool `json:"core_dumped"`
+	// Backtrace - стек процесса в момент доставки сигнала, начиная с текущей функции.
+	Backtrace []StackFrame `json:"backtrace,omitempty"`
+}
+
+// StackFrame - кадр стека процесса в момент ошибки выполнения.
+type StackFrame struct {
+	Address string `json:"address"`
+	// Module и Offset - файл, которому принадлежит адрес, и смещение в нем (для addr2line).
+	Module         string `json:"module,omitempty"`
+	Offset         string `json:"offset,omitempty"`
+	Function       string `json:"function,omitempty"`
+	FunctionOffset string `json:"function_offset,omitempty"`
+}
+
+func (e *RuntimeError) Error() string {
+	result := fmt.Sprintf("%s (%s", e.Description, e.SignalName)
+	if len(e.CodeName) > 0 {
+		result += ", " + e.CodeName
+	}
+	result += ")"
+	if len(e.Address) > 0 {
+		result += " at " + e.Address
+	}
+	if e.CoreDumped {
+		result += ", core dumped"
+	}
+	return result
+}
+
+// signalDescriptions - описания сигналов ошибок выполнения.
+var signalDescriptions = map[syscall.Signal]string{
+	syscall.SIGSEGV: "Segmentation fault",
+	syscall.SIGBUS:  "Bus error",
+	syscall.SIGFPE:  "Arithmetic exception",
+	syscall.SIGILL:  "Illegal instruction",
+	syscall.SIGABRT: "Aborted",
+}
+
+// handleableSignal возвращает true для сигналов ошибок выполнения, которые программа может
+// обработать сама ("--allow-signal-handlers").
+func handleableSignal(sig syscall.Signal) bool {
+	_, ok := signalDescriptions[sig]
+	return ok
+}
+
+func newRuntimeError(pid int, sig syscall.Signal) *RuntimeError {
+	description, ok := signalDescriptions[sig]
+	if !ok {
+		description = fmt.Sprintf("Killed by signal \"%s\"", sig)
+	}
+	return &RuntimeError{
+		PID:         pid,
+		Signal:      int(sig),
+		SignalName:  system.SignalName(sig),
+		Description: description,
+	}
+}
+
+// inspectSignal классифицирует сигнал <sig>, на котором остановлен процесс <pid>: читает
+// siginfo, а для сигналов ошибок выполнения - наличие обработчика и области памяти.
+func inspectSignal(processes *processTable, pid int, sig syscall.Signal) *RuntimeError {
+	e := newRuntimeError(pid, sig)
+
+	info, err := system.GetSiginfo(pid)
+	if err != nil {
+		log.Debugf("Unable to get siginfo of process %d: %v\n", pid, err)
+		return e
+	}
+	e.Code = info.Code
+	e.CodeName, _ = system.SignalCodeName(sig, info.Code)
+	if system.FaultSignal(sig) && info.Code > 0 && info.Code != system.SignalKernel {
+		e.Address = fmt.Sprintf("0x%x", info.Addr)
+	}
+
+	if !handleableSignal(sig) {
+		return e
+	}
+
+	procfsPid, err := processes.procfs(pid)
+	if err != nil {
+		return e
+	}
+	if status, err := system.GetProcessStatus(procfsPid); err == nil {
+		e.Handled = status.Handles(sig)
+	}
+
+	if sig == syscall.SIGSEGV && len(e.Address) > 0 {
+		mappings, err := system.GetMemoryMappings(procfsPid)
+		if err != nil {
+			return e
+		}
+		for _, m := range mappings {
+			if m.Path == "[stack]" && info.Addr < m.Start && m.Start-info.Addr <= stackGuardGap {
+				e.StackOverflow = true
+				e.Description = "Stack overflow"
+			}
+		}
+	}
+	return e
+}
+
+// unwind сохраняет стек процесса <pid>, остановленного на сигнале ошибки.
+func (e *RuntimeError) unwind(processes *processTable, pid int, workingDir string) {
+	procfsPid, err := processes.procfs(pid)
+	if err != nil {
+		return
+	}
+	frames, err := system.GetBacktrace(pid, procfsPid, workingDir)
+	if err != nil {
+		log.Debugf("Unable to get backtrace of process %d: %v\n", pid, err)
+		return
+	}
+
+	e.Backtrace = make([]StackFrame, len(frames))
+	for i, frame := range frames {
+		log.Debugf("#%d %s\n", i, frame.String())
+
+		e.Backtrace[i].Address = fmt.Sprintf("0x%x", frame.PC)
+		if len(frame.Module) > 0 {
+			e.Backtrace[i].Module = frame.Module
+			e.Backtrace[i].Offset = fmt.Sprintf("0x%x", frame.Offset)
+		}
+		if len(frame.Function) > 0 {
+			e.Backtrace[i].Function = frame.Function
+			e.Backtrace[i].FunctionOffset = fmt.Sprintf("0x%x", frame.FunctionOffset)
+		}
+	}
+}
+
+// terminated дополняет ошибку сведениями из статуса завершения процесса <ws>.
+func (e *RuntimeError) terminated(ws syscall.WaitStatus) *RuntimeError {
+	e.CoreDumped = ws.CoreDump()
+	return e
+}
@@ -0,0 +1,163 @@
+package instance
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/solovev/orange-app-runner/system"
+)
+
+// Форматы файла "--stats".
+const (
+	StatsCSV   = "csv"
+	StatsJSONL = "jsonl"
+)
+
+var statsColumns = []string{"time", "cpu_time", "rss", "hwm", "threads", "processes", "read_bytes", "write_bytes"}
+
+// statsSample - показатели tracee и его потомков в момент <Time> (миллисекунды от запуска).
+// Время процессора, память, потоки и ввод-вывод суммируются по процессам, кроме <HWM> -
+// наибольшего пикового размера резидентной памяти процесса.
+type statsSample struct {
+	Time       float64 `json:"time"`
+	CPUTime    float64 `json:"cpu_time"`
+	RSS        int64   `json:"rss"`
+	HWM        int64   `json:"hwm"`
+	Threads    int64   `json:"threads"`
+	Processes  int     `json:"processes"`
+	ReadBytes  uint64  `json:"read_bytes"`
+	WriteBytes uint64  `json:"write_bytes"`
+}
+
+func (s *statsSample) record() []string {
+	return []string{
+		strconv.FormatFloat(s.Time, 'f', 3, 64),
+		strconv.FormatFloat(s.CPUTime, 'f', 3, 64),
+		strconv.FormatInt(s.RSS, 10),
+		strconv.FormatInt(s.HWM, 10),
+		strconv.FormatInt(s.Threads, 10),
+		strconv.Itoa(s.Processes),
+		strconv.FormatUint(s.ReadBytes, 10),
+		strconv.FormatUint(s.WriteBytes, 10),
+	}
+}
+
+// OpenStats открывает файл "--stats". Должна вызываться tracer'ом до pivot_root.
+func (cfg *Config) OpenStats() error {
+	if len(cfg.StatsPath) == 0 {
+		return nil
+	}
+	if cfg.StatsInterval <= 0 {
+		return fmt.Errorf("Stats interval must be positive, got %dms", cfg.StatsInterval)
+	}
+
+	f, err := os.OpenFile(cfg.StatsPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return fmt.Errorf("Unable to open \"%s\": %v", cfg.StatsPath, err)
+	}
+	cfg.StatsFile = f
+	return nil
+}
+
+// statsWriter записывает показатели в файл "--stats" в формате <format>. При повторных
+// запусках в одном tracer'е (пакетный режим) заголовок CSV не повторяется.
+type statsWriter struct {
+	csv  *csv.Writer
+	json *json.Encoder
+}
+
+func newStatsWriter(f *os.File, format string) (*statsWriter, error) {
+	if format == StatsJSONL {
+		return &statsWriter{json: json.NewEncoder(f)}, nil
+	}
+
+	w := &statsWriter{csv: csv.NewWriter(f)}
+	if offset, err := f.Seek(0, io.SeekCurrent); err == nil && offset > 0 {
+		return w, nil
+	}
+	if err := w.csv.Write(statsColumns); err != nil {
+		return nil, err
+	}
+	w.csv.Flush()
+	return w, w.csv.Error()
+}
+
+func (w *statsWriter) write(sample *statsSample) error {
+	if w.json != nil {
+		return w.json.Encode(sample)
+	}
+
+	if err := w.csv.Write(sample.record()); err != nil {
+		return err
+	}
+	// Данные сбрасываются сразу, чтобы они сохранились, если tracer будет убит.
+	w.csv.Flush()
+	return w.csv.Error()
+}
+
+// startCollectingStats записывает показатели tracee в файл "--stats" каждые cfg.StatsInterval
+// миллисекунд. Ошибки записи не прерывают запуск.
+func startCollectingStats(tracee *traceeInstance, cfg *Config, started time.Time) {
+	tracee.wg.Add(1)
+	defer tracee.wg.Done()
+
+	log.Debugln("Goroutine \"startCollectingStats\" started")
+	defer log.Debugln("Goroutine \"startCollectingStats\" terminated")
+
+	writer, err := newStatsWriter(cfg.StatsFile, cfg.StatsFormat)
+	if err != nil {
+		log.Warnf("Unable to write stats: %v\n", err)
+		return
+	}
+
+	pid, err := system.ProcfsPid(tracee.process.Pid)
+	if err != nil {
+		log.Warnf("Unable to collect stats: %v\n", err)
+		return
+	}
+
+	ticker := time.NewTicker(time.Duration(cfg.StatsInterval) * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-tracee.stopc:
+			return
+		case <-ticker.C:
+			processes, err := system.GetProcessTreeUsage(pid)
+			if err != nil {
+				log.Debugf("Unable to collect stats: %v\n", err)
+				continue
+			}
+
+			sample := newStatsSample(processes, time.Since(started))
+			if err := writer.write(sample); err != nil {
+				log.Warnf("Unable to write stats: %v\n", err)
+				return
+			}
+		}
+	}
+}
+
+func newStatsSample(processes []system.ProcessUsage, elapsed time.Duration) *statsSample {
+	sample := &statsSample{
+		Time:      float64(elapsed.Nanoseconds()) / float64(time.Millisecond),
+		Processes: len(processes),
+	}
+	for _, p := range processes {
+		sample.CPUTime += float64(p.CPUTime) * 1000.0 / system.ClockTicks
+		sample.RSS += p.VmRSS
+		if p.VmHWM > sample.HWM {
+			sample.HWM = p.VmHWM
+		}
+		sample.Threads += p.Threads
+		sample.ReadBytes += p.ReadBytes
+		sample.WriteBytes += p.WriteBytes
+	}
+	return sample
+}
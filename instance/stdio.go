@@ -0,0 +1,62 @@
+package instance
+
+import (
+	"fmt"
+	"os"
+)
+
+// inheritStdio - значение параметров "--stdin", "--stdout" и "--stderr",
+// при котором tracee использует поток tracer'а.
+const inheritStdio = "-"
+
+// OpenStdio открывает файлы для перенаправления стандартных потоков tracee.
+// Должна вызываться tracer'ом до pivot_root, чтобы пути разрешались относительно хоста.
+func (cfg *Config) OpenStdio() error {
+	stdin, err := openStdioFile(cfg.InputFile, os.Stdin, os.O_RDONLY)
+	if err != nil {
+		return err
+	}
+
+	stdout, err := openStdioFile(cfg.OutputFile, os.Stdout, os.O_WRONLY|os.O_CREATE|os.O_TRUNC)
+	if err != nil {
+		closeStdio([]*os.File{stdin})
+		return err
+	}
+
+	stderr, err := openStdioFile(cfg.ErrorFile, os.Stderr, os.O_WRONLY|os.O_CREATE|os.O_TRUNC)
+	if err != nil {
+		closeStdio([]*os.File{stdin, stdout})
+		return err
+	}
+
+	cfg.Stdio = []*os.File{stdin, stdout, stderr}
+	return nil
+}
+
+func (cfg *Config) stdio() []*os.File {
+	if len(cfg.Stdio) == 0 {
+		return []*os.File{os.Stdin, os.Stdout, os.Stderr}
+	}
+	return cfg.Stdio
+}
+
+func openStdioFile(path string, inherited *os.File, flag int) (*os.File, error) {
+	if len(path) == 0 || path == inheritStdio {
+		return inherited, nil
+	}
+
+	f, err := os.OpenFile(path, flag, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to open \"%s\": %v", path, err)
+	}
+	return f, nil
+}
+
+// closeStdio закрывает открытые tracer'ом файлы, не трогая его собственные потоки.
+func closeStdio(files []*os.File) {
+	for _, f := range files {
+		if f != nil && f != os.Stdin && f != os.Stdout && f != os.Stderr {
+			f.Close()
+		}
+	}
+}
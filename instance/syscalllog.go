@@ -0,0 +1,408 @@
+package instance
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/solovev/orange-app-runner/system"
+	"golang.org/x/sys/unix"
+)
+
+// syscallArg - способ вывода аргумента системного вызова в "--syscall-log".
+type syscallArg int
+
+const (
+	// argInt - аргумент типа int, argSize - 64-битный (size_t, off_t, long).
+	argInt syscallArg = iota
+	argSize
+	argHex
+	argPtr
+	argFd
+	// argDirFd - дескриптор каталога для *at вызовов (AT_FDCWD).
+	argDirFd
+	argMode
+	argPath
+)
+
+// maxLoggedPath - наибольшая длина пути, читаемого из памяти tracee для "--syscall-log".
+const maxLoggedPath = 256
+
+// syscallArgs описывает аргументы распространенных системных вызовов. Для остальных
+// выводятся первые три аргумента в шестнадцатеричном виде.
+var syscallArgs = map[string][]syscallArg{
+	"read":              {argFd, argPtr, argSize},
+	"write":             {argFd, argPtr, argSize},
+	"open":              {argPath, argHex, argMode},
+	"close":             {argFd},
+	"stat":              {argPath, argPtr},
+	"fstat":             {argFd, argPtr},
+	"lstat":             {argPath, argPtr},
+	"poll":              {argPtr, argInt, argInt},
+	"lseek":             {argFd, argSize, argInt},
+	"mmap":              {argPtr, argSize, argHex, argHex, argFd, argHex},
+	"mprotect":          {argPtr, argSize, argHex},
+	"munmap":            {argPtr, argSize},
+	"brk":               {argPtr},
+	"rt_sigaction":      {argInt, argPtr, argPtr, argInt},
+	"rt_sigprocmask":    {argInt, argPtr, argPtr, argInt},
+	"ioctl":             {argFd, argHex, argPtr},
+	"pread64":           {argFd, argPtr, argSize, argSize},
+	"pwrite64":          {argFd, argPtr, argSize, argSize},
+	"readv":             {argFd, argPtr, argInt},
+	"writev":            {argFd, argPtr, argInt},
+	"access":            {argPath, argInt},
+	"pipe":              {argPtr},
+	"pipe2":             {argPtr, argHex},
+	"dup":               {argFd},
+	"dup2":              {argFd, argFd},
+	"dup3":              {argFd, argFd, argHex},
+	"nanosleep":         {argPtr, argPtr},
+	"getpid":            {},
+	"getppid":           {},
+	"gettid":            {},
+	"getuid":            {},
+	"geteuid":           {},
+	"getgid":            {},
+	"getegid":           {},
+	"getpgrp":           {},
+	"socket":            {argInt, argInt, argInt},
+	"connect":           {argFd, argPtr, argInt},
+	"clone":             {argHex, argPtr, argPtr, argPtr, argHex},
+	"clone3":            {argPtr, argSize},
+	"fork":              {},
+	"vfork":             {},
+	"execve":            {argPath, argPtr, argPtr},
+	"execveat":          {argDirFd, argPath, argPtr, argPtr, argHex},
+	"exit":              {argInt},
+	"exit_group":        {argInt},
+	"wait4":             {argInt, argPtr, argHex, argPtr},
+	"kill":              {argInt, argInt},
+	"tgkill":            {argInt, argInt, argInt},
+	"uname":             {argPtr},
+	"fcntl":             {argFd, argInt, argHex},
+	"getcwd":            {argPtr, argSize},
+	"chdir":             {argPath},
+	"fchdir":            {argFd},
+	"rename":            {argPath, argPath},
+	"mkdir":             {argPath, argMode},
+	"rmdir":             {argPath},
+	"creat":             {argPath, argMode},
+	"link":              {argPath, argPath},
+	"unlink":            {argPath},
+	"symlink":           {argPath, argPath},
+	"readlink":          {argPath, argPtr, argSize},
+	"chmod":             {argPath, argMode},
+	"truncate":          {argPath, argSize},
+	"ftruncate":         {argFd, argSize},
+	"getdents64":        {argFd, argPtr, argInt},
+	"arch_prctl":        {argHex, argPtr},
+	"futex":             {argPtr, argInt, argInt, argPtr, argPtr, argInt},
+	"set_tid_address":   {argPtr},
+	"set_robust_list":   {argPtr, argSize},
+	"clock_gettime":     {argInt, argPtr},
+	"clock_nanosleep":   {argInt, argHex, argPtr, argPtr},
+	"sched_getaffinity": {argInt, argSize, argPtr},
+	"sched_yield":       {},
+	"openat":            {argDirFd, argPath, argHex, argMode},
+	"mkdirat":           {argDirFd, argPath, argMode},
+	"newfstatat":        {argDirFd, argPath, argPtr, argHex},
+	"unlinkat":          {argDirFd, argPath, argHex},
+	"renameat":          {argDirFd, argPath, argDirFd, argPath},
+	"renameat2":         {argDirFd, argPath, argDirFd, argPath, argHex},
+	"linkat":            {argDirFd, argPath, argDirFd, argPath, argHex},
+	"symlinkat":         {argPath, argDirFd, argPath},
+	"readlinkat":        {argDirFd, argPath, argPtr, argSize},
+	"fchmodat":          {argDirFd, argPath, argMode},
+	"faccessat":         {argDirFd, argPath, argInt},
+	"faccessat2":        {argDirFd, argPath, argInt, argHex},
+	"statx":             {argDirFd, argPath, argHex, argHex, argPtr},
+	"prlimit64":         {argInt, argInt, argPtr, argPtr},
+	"getrandom":         {argPtr, argSize, argHex},
+	"rseq":              {argPtr, argInt, argHex, argHex},
+}
+
+// syscallResults - вызовы, результат которых является адресом.
+var syscallResults = map[string]bool{
+	"mmap": true,
+	"brk":  true,
+}
+
+// OpenSyscallLog открывает файл "--syscall-log". Должна вызываться tracer'ом до pivot_root.
+func (cfg *Config) OpenSyscallLog() error {
+	if len(cfg.SyscallLogPath) == 0 {
+		return nil
+	}
+
+	f, err := os.OpenFile(cfg.SyscallLogPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return fmt.Errorf("Unable to open \"%s\": %v", cfg.SyscallLogPath, err)
+	}
+	cfg.SyscallLogFile = f
+	return nil
+}
+
+// syscallCall - системный вызов, на входе в который остановлен процесс.
+type syscallCall struct {
+	name    string
+	args    string
+	entered time.Time
+	skip    bool
+}
+
+// syscallStats - итоги по одному системному вызову.
+type syscallStats struct {
+	name   string
+	calls  int
+	errors int
+	time   time.Duration
+}
+
+// syscallLogger записывает системные вызовы tracee в "--syscall-log". Вызов записывается
+// одной строкой при выходе из него: время входа (секунды от запуска tracee), pid, имя,
+// аргументы, результат и время между остановками на входе и выходе.
+type syscallLogger struct {
+	w       *bufio.Writer
+	started time.Time
+	calls   map[int]*syscallCall
+	stats   map[string]*syscallStats
+}
+
+func newSyscallLogger(f *os.File, started time.Time) *syscallLogger {
+	return &syscallLogger{
+		w:       bufio.NewWriter(f),
+		started: started,
+		calls:   make(map[int]*syscallCall),
+		stats:   make(map[string]*syscallStats),
+	}
+}
+
+func (l *syscallLogger) printf(pid int, at time.Time, format string, a ...interface{}) {
+	fmt.Fprintf(l.w, "%.6f [%d] ", at.Sub(l.started).Seconds(), pid)
+	fmt.Fprintf(l.w, format, a...)
+	l.w.WriteByte('\n')
+}
+
+// stop обрабатывает остановку процесса <pid> на входе в системный вызов или выходе из него.
+func (l *syscallLogger) stop(pid int) error {
+	now := time.Now()
+	state, err := system.GetSyscallState(pid)
+	if err != nil {
+		return err
+	}
+	name := system.SyscallName(state.Nr)
+
+	call, ok := l.calls[pid]
+	if !ok && state.Result == -int64(syscall.ENOSYS) {
+		// На входе в вызов ядро x86-64 записывает в rax -ENOSYS.
+		l.calls[pid] = &syscallCall{name: name, args: formatSyscallArgs(pid, name, state.Args), entered: now}
+		return nil
+	}
+	delete(l.calls, pid)
+	if ok && call.skip {
+		return nil
+	}
+	if !ok {
+		// Выход без входа: новый процесс возвращается из fork или clone.
+		call = &syscallCall{name: name, args: formatSyscallArgs(pid, name, state.Args), entered: now}
+	}
+
+	stats := l.stats[call.name]
+	if stats == nil {
+		stats = &syscallStats{name: call.name}
+		l.stats[call.name] = stats
+	}
+	stats.calls++
+	stats.time += now.Sub(call.entered)
+
+	result := formatSyscallResult(call.name, state.Result)
+	if state.Result < 0 && state.Result >= -4095 {
+		stats.errors++
+	}
+	l.printf(pid, call.entered, "%s(%s) = %s <%.6f>", call.name, call.args, result, now.Sub(call.entered).Seconds())
+	return nil
+}
+
+// skip пропускает выход процесса <pid> из текущего вызова, вход в который не записан
+// (execve, которым executor запускает целевую программу).
+func (l *syscallLogger) skip(pid int) {
+	l.calls[pid] = &syscallCall{skip: true}
+}
+
+// signal записывает сигнал, доставляемый процессу <pid>.
+func (l *syscallLogger) signal(pid int, e *RuntimeError) {
+	info := e.SignalName
+	if len(e.CodeName) > 0 {
+		info += " {si_code=" + e.CodeName
+		if len(e.Address) > 0 {
+			info += ", si_addr=" + e.Address
+		}
+		info += "}"
+	}
+	l.printf(pid, time.Now(), "--- %s ---", info)
+}
+
+// exited записывает завершение процесса <pid> и незавершенный им вызов (exit, exit_group).
+func (l *syscallLogger) exited(pid int, ws syscall.WaitStatus) {
+	now := time.Now()
+	l.unfinished(pid)
+	if ws.Signaled() {
+		core := ""
+		if ws.CoreDump() {
+			core = " (core dumped)"
+		}
+		l.printf(pid, now, "+++ killed by %s%s +++", system.SignalName(ws.Signal()), core)
+	} else {
+		l.printf(pid, now, "+++ exited with %d +++", ws.ExitStatus())
+	}
+}
+
+func (l *syscallLogger) unfinished(pid int) {
+	call, ok := l.calls[pid]
+	if !ok {
+		return
+	}
+	delete(l.calls, pid)
+	if call.skip {
+		return
+	}
+
+	stats := l.stats[call.name]
+	if stats == nil {
+		stats = &syscallStats{name: call.name}
+		l.stats[call.name] = stats
+	}
+	stats.calls++
+	l.printf(pid, call.entered, "%s(%s) = ?", call.name, call.args)
+}
+
+// close записывает вызовы, из которых процессы так и не вышли (например, завершенные
+// нарушением ограничений), и итоговую таблицу в формате "strace -c".
+func (l *syscallLogger) close() error {
+	pids := make([]int, 0, len(l.calls))
+	for pid := range l.calls {
+		pids = append(pids, pid)
+	}
+	sort.Ints(pids)
+	for _, pid := range pids {
+		l.unfinished(pid)
+	}
+
+	var stats []*syscallStats
+	var total syscallStats
+	for _, s := range l.stats {
+		stats = append(stats, s)
+		total.calls += s.calls
+		total.errors += s.errors
+		total.time += s.time
+	}
+	sort.Slice(stats, func(i, j int) bool {
+		if stats[i].time != stats[j].time {
+			return stats[i].time > stats[j].time
+		}
+		return stats[i].calls > stats[j].calls
+	})
+
+	separator := "------ ----------- ----------- --------- --------- ----------------"
+	fmt.Fprintln(l.w)
+	fmt.Fprintf(l.w, "%6s %11s %11s %9s %9s %s\n", "% time", "seconds", "usecs/call", "calls", "errors", "syscall")
+	fmt.Fprintln(l.w, separator)
+	for _, s := range stats {
+		percent := 0.0
+		if total.time > 0 {
+			percent = 100 * float64(s.time) / float64(total.time)
+		}
+		fmt.Fprintf(l.w, "%6.2f %11.6f %11d %9d %9s %s\n", percent, s.time.Seconds(),
+			int64(s.time/time.Microsecond)/int64(s.calls), s.calls, formatErrors(s.errors), s.name)
+	}
+	fmt.Fprintln(l.w, separator)
+	fmt.Fprintf(l.w, "%6.2f %11.6f %11s %9d %9s %s\n", 100.0, total.time.Seconds(), "", total.calls, formatErrors(total.errors), "total")
+	return l.w.Flush()
+}
+
+func formatErrors(errors int) string {
+	if errors == 0 {
+		return ""
+	}
+	return strconv.Itoa(errors)
+}
+
+func formatSyscallArgs(pid int, name string, args [6]uint64) string {
+	kinds, ok := syscallArgs[name]
+	if !ok {
+		kinds = []syscallArg{argHex, argHex, argHex}
+	}
+
+	values := make([]string, len(kinds))
+	for i, kind := range kinds {
+		arg := args[i]
+		switch kind {
+		case argInt, argFd:
+			values[i] = strconv.Itoa(int(int32(arg)))
+		case argSize:
+			values[i] = strconv.FormatInt(int64(arg), 10)
+		case argDirFd:
+			if int32(arg) == unix.AT_FDCWD {
+				values[i] = "AT_FDCWD"
+			} else {
+				values[i] = strconv.Itoa(int(int32(arg)))
+			}
+		case argMode:
+			values[i] = fmt.Sprintf("0%o", arg)
+		case argPtr:
+			if arg == 0 {
+				values[i] = "NULL"
+			} else {
+				values[i] = fmt.Sprintf("0x%x", arg)
+			}
+		case argPath:
+			values[i] = formatSyscallPath(pid, arg)
+		default:
+			values[i] = fmt.Sprintf("0x%x", arg)
+		}
+	}
+	return strings.Join(values, ", ")
+}
+
+func formatSyscallPath(pid int, addr uint64) string {
+	if addr == 0 {
+		return "NULL"
+	}
+	path, truncated, err := system.ReadString(pid, addr, maxLoggedPath)
+	if err != nil {
+		return fmt.Sprintf("0x%x", addr)
+	}
+	result := strconv.Quote(path)
+	if truncated {
+		result += "..."
+	}
+	return result
+}
+
+// restartErrors - внутренние коды ошибок ядра, которые процесс видит только при трассировке:
+// вызов прерван сигналом и будет перезапущен.
+var restartErrors = map[syscall.Errno]string{
+	512: "ERESTARTSYS",
+	513: "ERESTARTNOINTR",
+	514: "ERESTARTNOHAND",
+	516: "ERESTART_RESTARTBLOCK",
+}
+
+func formatSyscallResult(name string, result int64) string {
+	if result < 0 && result >= -4095 {
+		errno := syscall.Errno(-result)
+		if restart, ok := restartErrors[errno]; ok {
+			return fmt.Sprintf("? %s (interrupted by signal)", restart)
+		}
+		return fmt.Sprintf("-1 %s (%s)", unix.ErrnoName(errno), errno.Error())
+	}
+	if syscallResults[name] {
+		return fmt.Sprintf("0x%x", uint64(result))
+	}
+	return strconv.FormatInt(result, 10)
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/solovev/orange-app-runner/checker"
+	"github.com/solovev/orange-app-runner/instance"
+	"github.com/solovev/orange-app-runner/util"
+)
+
+// interactorOptions - параметры запуска интерактивных задач.
+type interactorOptions struct {
+	InteractorPath          string  `long:"interactor" description:"Run tracee together with the specified testlib interactor (\"<interactor> <input> <output> [<answer>]\"), stdout of tracee is connected to stdin of the interactor and vice versa, --stdin and --stdout files are passed to the interactor"`
+	InteractorRealTimeLimit int64   `long:"interactor-rt-limit" description:"Terminate interactor after specified milliseconds" default:"-1"`
+	InteractorCPUTimeLimit  float64 `long:"interactor-cput-limit" description:"Terminate interactor if it has used more than specified CPU time in milliseconds" default:"-1"`
+	InteractorMemoryLimit   int64   `long:"interactor-mem-limit" description:"Terminate interactor if its memory consumption exceeds the specified number of kilobytes" default:"-1"`
+}
+
+// checkInteractor проверяет параметры интерактивного запуска.
+func checkInteractor() error {
+	if cfg.InputFile == "-" || cfg.OutputFile == "-" {
+		return fmt.Errorf("Option \"--interactor\" requires --stdin and --stdout to be files")
+	}
+
+	path, err := util.CheckIsBinaryExists(interactorCfg.InteractorPath)
+	if err != nil {
+		return fmt.Errorf("Unable to locate interactor \"%s\": %v", interactorCfg.InteractorPath, err)
+	}
+	interactorCfg.InteractorPath = path
+	return nil
+}
+
+// runInteractive запускает tracee и интерактор в отдельных песочницах, соединяя их
+// стандартные потоки, и возвращает код выхода и отчет с итоговым вердиктом.
+func runInteractive() (int, *instance.Report) {
+	// Вывод tracee -> ввод интерактора.
+	interactorIn, solutionOut, err := os.Pipe()
+	if err != nil {
+		log.Fatalf("Error creating interactor pipe: %v\n", err)
+	}
+	// Вывод интерактора -> ввод tracee.
+	solutionIn, interactorOut, err := os.Pipe()
+	if err != nil {
+		log.Fatalf("Error creating interactor pipe: %v\n", err)
+	}
+
+	comment, err := ioutil.TempFile("", "oar-interactor-")
+	if err != nil {
+		log.Fatalf("Error creating interactor comment file: %v\n", err)
+	}
+	comment.Close()
+	defer os.Remove(comment.Name())
+
+	interactorArgs, err := interactorTracerArgs(comment.Name())
+	if err != nil {
+		log.Fatalf("Error preparing interactor arguments: %v\n", err)
+	}
+
+	var interactorReport *instance.Report
+	wg := &sync.WaitGroup{}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		_, interactorReport = startSandbox(&instance.Config{}, interactorCfg.InteractorPath, interactorArgs, sandboxOptions{
+			report: true,
+			stdin:  interactorIn,
+			stdout: interactorOut,
+		})
+	}()
+
+	solutionArgs := insertTracerOptions(os.Args[1:], "--stdin=-", "--stdout=-")
+	exitCode, report := startSandbox(&cfg, processPath, solutionArgs, sandboxOptions{
+		report: true,
+		stdin:  solutionIn,
+		stdout: solutionOut,
+	})
+	wg.Wait()
+	log.Debugf("Interactor report: %+v\n", interactorReport)
+
+	data, err := ioutil.ReadFile(comment.Name())
+	if err != nil {
+		log.Warnf("Unable to read interactor comment: %v\n", err)
+	}
+
+	report.Interactor = interactorReport
+	result := sandboxedTestlibResult("Interactor", interactorReport, string(data))
+	return interactiveVerdict(report, result, exitCode), report
+}
+
+// interactiveVerdict определяет итоговый вердикт интерактивного запуска. Ошибки tracee
+// (превышение ограничений, RE, SV) имеют приоритет над результатом интерактора, кроме
+// завершения по SIGPIPE, которое возникает, если интерактор закончил работу первым.
+func interactiveVerdict(report *instance.Report, result *checker.Result, exitCode int) int {
+	brokenPipe := report.Verdict == instance.VerdictRuntimeError && report.Signal == int(syscall.SIGPIPE)
+	if report.Verdict != instance.VerdictOK && !brokenPipe {
+		return exitCode
+	}
+	if brokenPipe && result.Verdict == checker.VerdictOK {
+		return exitCode
+	}
+
+	report.Check = result
+	report.Verdict = instance.Verdict(result.Verdict)
+	if result.Verdict == checker.VerdictFail {
+		report.Tag = "interactor"
+		report.Error = result.Message
+	}
+
+	if code, ok := runCheckExitCodes[result.Verdict]; ok {
+		return code
+	}
+	return exitCode
+}
+
+// interactorTracerArgs возвращает параметры tracer'а для запуска интерактора.
+// Комментарий интерактора (stderr) записывается в файл <commentPath>.
+func interactorTracerArgs(commentPath string) ([]string, error) {
+	output := cfg.OutputFile
+	if len(output) == 0 {
+		output = os.DevNull
+	}
+
+	files := []string{cfg.InputFile, output}
+	if len(answerCfg.AnswerFile) > 0 {
+		files = append(files, answerCfg.AnswerFile)
+	}
+	for i, path := range files {
+		abs, err := filepath.Abs(path)
+		if err != nil {
+			return nil, err
+		}
+		files[i] = abs
+	}
+
+	args := []string{
+		"--stdin=-",
+		"--stdout=-",
+		"--stderr=" + commentPath,
+		"--report=-",
+		fmt.Sprintf("--rt-limit=%d", interactorCfg.InteractorRealTimeLimit),
+		fmt.Sprintf("--cput-limit=%v", interactorCfg.InteractorCPUTimeLimit),
+		fmt.Sprintf("--mem-limit=%d", interactorCfg.InteractorMemoryLimit),
+	}
+	if cfg.Debug {
+		args = append(args, "--debug")
+	}
+	args = append(args, "--", interactorCfg.InteractorPath)
+	return append(args, files...), nil
+}
+
+// insertTracerOptions добавляет параметры <options> после параметров командной строки <args>,
+// но перед "--", чтобы они имели приоритет над заданными пользователем.
+func insertTracerOptions(args []string, options ...string) []string {
+	index := len(args)
+	for i, arg := range args {
+		if arg == "--" {
+			index = i
+			break
+		}
+	}
+
+	result := append([]string{}, args[:index]...)
+	result = append(result, options...)
+	return append(result, args[index:]...)
+}
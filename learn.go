@@ -0,0 +1,98 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+
+	"github.com/jessevdk/go-flags"
+	log "github.com/sirupsen/logrus"
+	"github.com/solovev/orange-app-runner/instance"
+	"github.com/solovev/orange-app-runner/util"
+)
+
+// learnOptions - параметры режима "oar learn".
+type learnOptions struct {
+	PolicyOutput string `long:"policy-output" description:"Write the learned policy to the specified file (JSON for the \".json\" extension, YAML otherwise) instead of standard output"`
+	PolicyName   string `long:"policy-name" description:"Set name of the learned policy (by default, name of the program)"`
+
+	// Tracer - служебный параметр, с которым oar запускает tracer в режиме обучения.
+	Tracer bool `long:"learn-tracer" hidden:"yes" description:"Collect the policy and return it in the report"`
+}
+
+// runLearn реализует режим "oar learn [<options>] <program> [<parameters>]": запускает эталонную
+// программу без ограничений на системные вызовы и создание процессов и записывает политику,
+// разрешающую все, что она использовала. Политику можно указать в "--policy".
+func runLearn(args []string) int {
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+
+	parser := newParser()
+	parser.Usage = "learn [--policy-output <file>] [<options>] <program> [<parameters>]"
+	parser.Group.Find("Learn mode").Hidden = false
+
+	rest, err := parser.ParseArgs(args)
+	if err != nil {
+		if flagsErr, ok := err.(*flags.Error); ok && flagsErr.Type == flags.ErrHelp {
+			return 0
+		}
+		return 1
+	}
+	applyArgs(rest)
+
+	if len(cfg.PolicyPath) > 0 || len(cfg.SeccompAllow) > 0 || len(cfg.SeccompDeny) > 0 {
+		log.Errorln("Options \"--policy\", \"--seccomp-allow\" and \"--seccomp-deny\" are not supported in learn mode")
+		return 1
+	}
+	if len(interactorCfg.InteractorPath) > 0 {
+		log.Errorln("Option \"--interactor\" is not supported in learn mode")
+		return 1
+	}
+	checkConfig()
+
+	tracerArgs := insertTracerOptions(args, "--learn-tracer", "--allow-create-processes", "--allow-multithreading", "--allow-exec")
+	exitCode, report := startSandbox(&cfg, processPath, tracerArgs, sandboxOptions{report: true})
+
+	if len(cfg.ReportPath) > 0 {
+		if err := writeReport(cfg.ReportPath, report); err != nil {
+			log.WithFields(log.Fields{
+				"path":  cfg.ReportPath,
+				"error": err,
+			}).Error("Failed to write report")
+		}
+	}
+
+	policy := report.LearnedPolicy
+	if policy == nil {
+		log.Errorf("Unable to learn policy: %s\n", report.Error)
+		return 1
+	}
+	if report.Verdict != instance.VerdictOK {
+		log.Warnf("Program finished with verdict %s, the learned policy may be incomplete\n", report.Verdict)
+	}
+
+	policy.Name = learnCfg.PolicyName
+	if len(policy.Name) == 0 {
+		policy.Name = filepath.Base(processPath)
+	}
+
+	if err := writePolicy(learnCfg.PolicyOutput, policy); err != nil {
+		log.Errorf("Unable to write policy: %v\n", err)
+		return 1
+	}
+	log.Infof("Learned policy \"%s\": %d syscalls, %d argument rules\n", policy.Name, len(policy.Syscalls.Allow), len(policy.Arguments))
+	return exitCode
+}
+
+// writePolicy записывает политику в файл <path> или в стандартный вывод, если путь не указан.
+func writePolicy(path string, policy *instance.Policy) error {
+	f := os.Stdout
+	if len(path) > 0 {
+		var err error
+		if f, err = util.CreateFile(path); err != nil {
+			return err
+		}
+		defer f.Close()
+	}
+	return policy.Write(f, filepath.Ext(path) == ".json")
+}
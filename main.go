@@ -1,24 +1,30 @@
 package main
 
 import (
+	"fmt"
+	"io"
 	"os"
 	"os/exec"
 	"os/user"
-	"path/filepath"
 	"runtime"
 	"strconv"
 	"syscall"
-	"time"
 
 	"github.com/docker/docker/pkg/reexec"
 	"github.com/jessevdk/go-flags"
 	log "github.com/sirupsen/logrus"
 	"github.com/solovev/orange-app-runner/instance"
+	"github.com/solovev/orange-app-runner/sandbox"
 	"github.com/solovev/orange-app-runner/system"
+	"github.com/solovev/orange-app-runner/util"
 )
 
 var (
-	cfg instance.Config
+	cfg           instance.Config
+	answerCfg     answerOptions
+	interactorCfg interactorOptions
+	batchCfg      batchOptions
+	learnCfg      learnOptions
 
 	processPath string
 	processArgs []string
@@ -27,15 +33,77 @@ var (
 const (
 	defaultProcess        = "/bin/sh"
 	wrapper        string = "ejudge_tracer"
+
+	// reportFd - дескриптор, через который tracer передает отчет родительскому процессу.
+	reportFd uintptr = 3
+	// cgroupFd - дескриптор файла "cgroup.procs", в который tracer добавляет tracee.
+	cgroupFd uintptr = 4
+	// batchFd - дескриптор, через который tracer получает задание пакетного запуска и команды продолжения.
+	batchFd uintptr = 5
 )
 
+// modes - режимы работы, выбираемые первым параметром командной строки вместо запуска программы.
+var modes = map[string]func(args []string) int{
+	"check": runCheck,
+	"batch": runBatch,
+	"serve": runServe,
+	"learn": runLearn,
+}
+
 func init() {
-	args, err := flags.Parse(&cfg)
+	// Tracer и executor песочницы, которую использует режим "oar serve".
+	if sandbox.Init() {
+		os.Exit(0)
+	}
+
+	if len(os.Args) > 1 {
+		if mode, ok := modes[os.Args[1]]; ok {
+			os.Exit(mode(os.Args[2:]))
+		}
+	}
+
+	args, err := newParser().Parse()
+
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	applyArgs(args)
+
+	reexec.Register(wrapper, startTracer)
+	if reexec.Init() {
+		os.Exit(0)
+	}
+}
+
+// newParser создает разборщик параметров запуска. Параметры пакетного режима скрыты
+// из справки, но разбираются всегда, т.к. tracer получает те же параметры, что и oar.
+func newParser() *flags.Parser {
+	parser := flags.NewParser(&cfg, flags.Default)
+	if _, err := parser.AddGroup("Answer checking", "", &answerCfg); err != nil {
+		log.Fatalln(err)
+	}
+	if _, err := parser.AddGroup("Interactive problems", "", &interactorCfg); err != nil {
+		log.Fatalln(err)
+	}
 
+	batch, err := parser.AddGroup("Batch mode", "", &batchCfg)
 	if err != nil {
 		log.Fatalln(err)
 	}
+	batch.Hidden = true
 
+	learn, err := parser.AddGroup("Learn mode", "", &learnCfg)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	learn.Hidden = true
+
+	return parser
+}
+
+// applyArgs применяет разобранные параметры: путь к программе, ее аргументы и уровень логирования.
+func applyArgs(args []string) {
 	if len(args) > 0 {
 		processPath = args[0]
 		if len(args) > 1 {
@@ -51,56 +119,135 @@ func init() {
 	if cfg.Debug {
 		log.SetLevel(log.DebugLevel)
 	}
-
-	reexec.Register(wrapper, startTracer)
-	if reexec.Init() {
-		os.Exit(0)
-	}
 }
 
-func startTracer() {
+// checkConfig проверяет параметры, общие для всех режимов, запускающих tracer.
+func checkConfig() {
 	if len(cfg.RootFS) > 0 {
-		path, err := filepath.Abs(cfg.RootFS)
+		err := cfg.CheckRootFS()
 		if err != nil {
-			log.Fatalln(err)
+			log.WithFields(log.Fields{
+				"path":  cfg.RootFS,
+				"error": err,
+			}).Fatal("Failed to locate rootfs directory")
 		}
+	} else {
+		log.Warn("Path to root filesystem is not specified, mount namespace cloning is disabled")
+	}
 
-		log.Infof("Root filesystem path: \"%s\"\n", path)
+	if err := cfg.CheckMounts(); err != nil {
+		log.WithFields(log.Fields{
+			"error": err,
+		}).Fatal("Invalid mount options")
+	}
 
-		if err := system.MountProc(path); err != nil {
+	if err := cfg.LoadPolicy(); err != nil {
+		log.WithFields(log.Fields{
+			"policy": cfg.PolicyPath,
+			"error":  err,
+		}).Fatal("Failed to load policy")
+	}
+
+	if len(cfg.NetSetGoPath) > 0 {
+		err := cfg.CheckNetSetGoPath()
+		if err != nil {
 			log.WithFields(log.Fields{
-				"path":  path,
+				"path":  cfg.NetSetGoPath,
 				"error": err,
-			}).Fatal("Failed to mount /proc")
+			}).Fatal("Failed to locate \"netsetgo\" binary file")
 		}
+	} else {
+		log.Warn("Path to \"netsetgo\" binary file is not specified, spawned process will not have any network connectivity")
+	}
+}
+
+func startTracer() {
+	var reportFile *os.File
+	if len(cfg.ReportPath) > 0 || batchCfg.Tracer || learnCfg.Tracer {
+		syscall.CloseOnExec(int(reportFd))
+		reportFile = os.NewFile(reportFd, "report")
+	}
+
+	if len(cfg.CgroupPath) > 0 {
+		syscall.CloseOnExec(int(cgroupFd))
+		cfg.CgroupProcs = os.NewFile(cgroupFd, "cgroup.procs")
+	}
+
+	if err := cfg.LoadPolicy(); err != nil {
+		log.WithFields(log.Fields{
+			"policy": cfg.PolicyPath,
+			"error":  err,
+		}).Fatal("Failed to load policy")
+	}
+	cfg.LearnPolicy = learnCfg.Tracer
 
-		if err := system.PivotRoot(path); err != nil {
+	var batch *batchTracer
+	if batchCfg.Tracer {
+		syscall.CloseOnExec(int(batchFd))
+
+		var err error
+		if batch, err = openBatch(os.NewFile(batchFd, "batch")); err != nil {
 			log.WithFields(log.Fields{
-				"path":  path,
 				"error": err,
-			}).Fatal("Error running pivot_root")
+			}).Fatal("Failed to prepare batch run")
 		}
+	} else if err := cfg.OpenStdio(); err != nil {
+		log.WithFields(log.Fields{
+			"error": err,
+		}).Fatal("Failed to open standard streams of tracee")
 	}
 
-	log.Infof("Setting hostname: \"%s\"\n", wrapper)
-	if err := syscall.Sethostname([]byte(wrapper)); err != nil {
+	if err := cfg.OpenStats(); err != nil {
 		log.WithFields(log.Fields{
-			"hostname": wrapper,
-			"error":    err,
-		}).Fatal("Error running hostname")
+			"path":  cfg.StatsPath,
+			"error": err,
+		}).Fatal("Failed to open stats file")
 	}
 
-	if len(cfg.NetSetGoPath) > 0 {
-		wait := 3 * time.Second
-		log.Infof("Waiting for network for %v...\n", wait)
-		if err := system.WaitForNetwork(wait); err != nil {
-			log.Fatalf("Error waiting for network: %v\n", err)
-		}
+	if err := cfg.OpenSyscallLog(); err != nil {
+		log.WithFields(log.Fields{
+			"path":  cfg.SyscallLogPath,
+			"error": err,
+		}).Fatal("Failed to open syscall log file")
 	}
 
-	exitCode, err := instance.Run(processPath, processArgs, &cfg)
+	releaseMounts, err := cfg.SetupNamespaces(wrapper)
 	if err != nil {
-		log.Warnf("Error running tracee process: %v\n", err)
+		log.WithFields(log.Fields{
+			"error": err,
+		}).Fatal("Failed to set up tracer namespaces")
+	}
+
+	var exitCode int
+	if batch != nil {
+		exitCode = batch.run(reportFile)
+	} else {
+		var report *instance.Report
+		var err error
+		exitCode, report, err = instance.Run(processPath, processArgs, &cfg)
+		if err != nil {
+			log.Warnf("Error running tracee process: %v\n", err)
+		}
+
+		if reportFile != nil {
+			if err := report.Write(reportFile); err != nil {
+				log.Warnf("Error sending report to the parent process: %v\n", err)
+			}
+		}
+	}
+
+	releaseMounts()
+	if reportFile != nil {
+		reportFile.Close()
+	}
+	if cfg.CgroupProcs != nil {
+		cfg.CgroupProcs.Close()
+	}
+	if cfg.StatsFile != nil {
+		cfg.StatsFile.Close()
+	}
+	if cfg.SyscallLogFile != nil {
+		cfg.SyscallLogFile.Close()
 	}
 
 	log.Infof("Tracer is terminated. Exit code: %d\n", exitCode)
@@ -112,32 +259,49 @@ func main() {
 	runtime.LockOSThread()
 	defer runtime.UnlockOSThread()
 
-	if len(cfg.RootFS) > 0 {
-		err := cfg.CheckRootFS()
-		if err != nil {
-			log.WithFields(log.Fields{
-				"path":  cfg.RootFS,
-				"error": err,
-			}).Fatal("Failed to locate rootfs directory")
+	checkConfig()
+
+	if len(interactorCfg.InteractorPath) > 0 {
+		if err := checkInteractor(); err != nil {
+			log.Fatalln(err)
 		}
+	}
+
+	if len(answerCfg.AnswerFile) > 0 {
+		if err := checkAnswerFile(); err != nil {
+			log.Fatalln(err)
+		}
+	}
+
+	var exitCode int
+	var report *instance.Report
+	if len(interactorCfg.InteractorPath) > 0 {
+		exitCode, report = runInteractive()
 	} else {
-		log.Warn("Path to root filesystem is not specified, mount namespace cloning is disabled")
+		exitCode, report = startSandbox(&cfg, processPath, os.Args[1:], sandboxOptions{report: len(cfg.ReportPath) > 0})
 	}
 
-	if len(cfg.NetSetGoPath) > 0 {
-		err := cfg.CheckNetSetGoPath()
-		if err != nil {
+	if len(answerCfg.AnswerFile) > 0 {
+		exitCode = checkOutput(report, exitCode)
+	}
+
+	if len(cfg.ReportPath) > 0 {
+		if err := writeReport(cfg.ReportPath, report); err != nil {
 			log.WithFields(log.Fields{
-				"path":  cfg.NetSetGoPath,
+				"path":  cfg.ReportPath,
 				"error": err,
-			}).Fatal("Failed to locate \"netsetgo\" binary file")
+			}).Error("Failed to write report")
 		}
-	} else {
-		log.Warn("Path to \"netsetgo\" binary file is not specified, spawned process will not have any network connectivity")
 	}
 
-	args := []string{wrapper}
-	args = append(args, os.Args[1:]...)
+	os.Exit(exitCode)
+}
+
+// startSandbox запускает tracer в новых пространствах имен с параметрами <tracerArgs>
+// (программа <path> и ее аргументы передаются в их конце) и дожидается его завершения.
+// Если отчет не запрашивается (<opts>.report), возвращается nil.
+func startSandbox(config *instance.Config, path string, tracerArgs []string, opts sandboxOptions) (int, *instance.Report) {
+	args := append([]string{wrapper}, tracerArgs...)
 
 	uid := os.Getuid()
 	gid := os.Getgid()
@@ -146,67 +310,140 @@ func main() {
 	if err != nil {
 		log.Warn(err)
 	}
-	log.Infof("Starting tracer for \"%s\" (As: \"%s\", UID: %d, GID: %d): %v...\n", processPath, u.Username, uid, gid, args)
+	log.Infof("Starting tracer for \"%s\" (As: \"%s\", UID: %d, GID: %d): %v...\n", path, u.Username, uid, gid, args)
 
 	cmd := reexec.Command(args...)
 	cmd.Stderr = os.Stderr
 	cmd.Stdout = os.Stdout
 	cmd.Stdin = os.Stdin
+	if opts.stdin != nil {
+		cmd.Stdin = opts.stdin
+	}
+	if opts.stdout != nil {
+		cmd.Stdout = opts.stdout
+	}
 
-	var cf uintptr
-	cf = syscall.CLONE_NEWUTS |
-		syscall.CLONE_NEWIPC |
-		syscall.CLONE_NEWPID |
-		syscall.CLONE_NEWNET |
-		syscall.CLONE_NEWUSER
+	cmd.ExtraFiles = make([]*os.File, 3)
+	cmd.ExtraFiles[batchFd-3] = opts.batch
 
-	if len(cfg.RootFS) > 0 {
-		cf |= syscall.CLONE_NEWNS
-	}
-
-	cmd.SysProcAttr = &syscall.SysProcAttr{
-		Cloneflags: cf,
-		UidMappings: []syscall.SysProcIDMap{
-			{
-				ContainerID: 0,
-				HostID:      uid,
-				Size:        1,
-			},
-		},
-		GidMappings: []syscall.SysProcIDMap{
-			{
-				ContainerID: 0,
-				HostID:      gid,
-				Size:        1,
-			},
-		},
+	var reportReader *os.File
+	if opts.report {
+		r, w, err := os.Pipe()
+		if err != nil {
+			log.WithFields(log.Fields{
+				"error": err,
+			}).Fatal("Error creating report pipe")
+		}
+
+		reportReader = r
+		cmd.ExtraFiles[reportFd-3] = w
 	}
 
+	var cg *system.Cgroup
+	if len(config.CgroupPath) > 0 {
+		cg, err = instance.CreateCgroup(config)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"path":  config.CgroupPath,
+				"error": err,
+			}).Fatal("Failed to create cgroup")
+		}
+
+		procs, err := cg.OpenProcs()
+		if err != nil {
+			cg.Remove()
+			log.WithFields(log.Fields{
+				"path":  cg.Path,
+				"error": err,
+			}).Fatal("Failed to open cgroup.procs")
+		}
+		cmd.ExtraFiles[cgroupFd-3] = procs
+	}
+
+	cmd.SysProcAttr = config.SysProcAttr()
+
 	if err := cmd.Start(); err != nil {
+		if cg != nil {
+			cg.Remove()
+		}
 		log.WithFields(log.Fields{
 			"error": err,
 		}).Fatal("Error starting the reexec.Command")
 	}
 
-	if len(cfg.NetSetGoPath) > 0 {
-		args = []string{"-pid", strconv.Itoa(cmd.Process.Pid)}
-		log.Infof("Starting \"netsetgo\" (%s), args: %v\n", cfg.NetSetGoPath, args)
+	for _, f := range append(cmd.ExtraFiles, opts.stdin, opts.stdout) {
+		if f != nil {
+			f.Close()
+		}
+	}
 
-		nsgcmd := exec.Command(cfg.NetSetGoPath, args...)
-		if err := nsgcmd.Run(); err != nil {
-			log.WithFields(log.Fields{
-				"error": err,
-			}).Fatal("Error starting \"netsetgo\" binary")
+	if err := config.StartNetSetGo(cmd.Process.Pid); err != nil {
+		log.WithFields(log.Fields{
+			"error": err,
+		}).Fatal("Failed to set up network")
+	}
+
+	readReport := instance.ReadReport
+	if opts.readReport != nil {
+		readReport = opts.readReport
+	}
+
+	var report *instance.Report
+	if reportReader != nil {
+		report, err = readReport(reportReader)
+		if err != nil {
+			log.Warnf("Unable to receive report from the tracer: %v\n", err)
 		}
+		reportReader.Close()
 	}
 
+	exitCode := 0
 	if err := cmd.Wait(); err != nil {
 		if exitError, ok := err.(*exec.ExitError); ok {
-			os.Exit(exitError.ExitCode())
+			exitCode = exitError.ExitCode()
 		} else {
 			log.WithFields(log.Fields{
 				"error": err,
 			}).Fatal("Error waiting for the reexec.Command")
 		}
 	}
+
+	if reportReader != nil && report == nil {
+		err := fmt.Errorf("Tracer exited without report (exit code: %d)", exitCode)
+		report = instance.FailedReport(err)
+	}
+
+	if cg != nil {
+		exitCode, err = instance.ApplyCgroupStats(cg, config, report, exitCode)
+		if err != nil {
+			log.Warnf("Unable to read cgroup stats: %v\n", err)
+		}
+		if err := cg.Remove(); err != nil {
+			log.Warnf("Unable to remove cgroup \"%s\": %v\n", cg.Path, err)
+		}
+	}
+
+	return exitCode, report
+}
+
+// sandboxOptions - дополнительные параметры запуска tracer'а.
+type sandboxOptions struct {
+	// report - запросить у tracer'а отчет.
+	report bool
+	// stdin и stdout становятся потоками tracer'а и закрываются после его запуска.
+	stdin, stdout *os.File
+	// batch передается tracer'у как дескриптор batchFd и закрывается после его запуска.
+	batch *os.File
+	// readReport читает отчет tracer'а, по умолчанию - instance.ReadReport.
+	readReport func(r io.Reader) (*instance.Report, error)
+}
+
+func writeReport(path string, report *instance.Report) error {
+	f, err := util.CreateFile(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return report.Write(f)
 }
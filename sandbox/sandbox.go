@@ -0,0 +1,218 @@
+// Package sandbox позволяет запускать программы в песочнице oar из Go кода без запуска
+// бинарного файла oar. Пространства имен по-прежнему создаются через reexec: tracer - это
+// повторно запущенный текущий бинарный файл, поэтому пакет регистрирует свою точку входа
+// при инициализации и должен быть импортирован программой, вызывающей Run.
+package sandbox
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"runtime"
+	"syscall"
+
+	"github.com/docker/docker/pkg/reexec"
+	log "github.com/sirupsen/logrus"
+	"github.com/solovev/orange-app-runner/instance"
+	"github.com/solovev/orange-app-runner/system"
+)
+
+// Spec описывает запуск программы в песочнице.
+type Spec struct {
+	// Path - путь к программе (внутри корневой ФС, если она указана), Args - ее аргументы.
+	Path string
+	Args []string
+
+	// Config содержит ограничения и параметры запуска, как в параметрах командной строки oar,
+	// начальное значение следует получать из instance.DefaultConfig. Поля InputFile, OutputFile
+	// и ErrorFile не используются, вместо них - Stdin, Stdout и Stderr.
+	Config instance.Config
+
+	// Stdin, Stdout и Stderr - стандартные потоки программы. Если поток не указан,
+	// используется /dev/null. Файлы передаются программе напрямую, для остальных
+	// потоков создаются pipe'ы.
+	Stdin  io.Reader
+	Stdout io.Writer
+	Stderr io.Writer
+}
+
+// Result - результат запуска: отчет tracer'а и его код выхода.
+type Result struct {
+	instance.Report
+
+	// ExitCode - код выхода tracer'а, как у oar: код TracerError при превышении
+	// ограничений или код выхода программы при Config.PropagateExitCode.
+	ExitCode int `json:"exit_code"`
+}
+
+// Sandbox запускает программы в песочнице. Нулевое значение готово к использованию,
+// методы Sandbox можно вызывать одновременно из нескольких горутин.
+type Sandbox struct {
+	// Log получает логи tracer'а, если не указан, логи отбрасываются.
+	Log io.Writer
+}
+
+// Run запускает программу в песочнице со значением Sandbox по умолчанию.
+func Run(ctx context.Context, spec *Spec) (*Result, error) {
+	return (&Sandbox{}).Run(ctx, spec)
+}
+
+// Run запускает программу <spec> в новых пространствах имен и дожидается ее завершения.
+// Превышение ограничений и ошибки программы не являются ошибками Run, они отражаются
+// в вердикте результата. При отмене <ctx> вся группа процессов tracer'а (вместе с его
+// пространством имен PID) уничтожается, а Run возвращает ошибку контекста.
+func (s *Sandbox) Run(ctx context.Context, spec *Spec) (*Result, error) {
+	if len(spec.Path) == 0 {
+		return nil, errors.New("Path to the program is not specified")
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	config := spec.Config
+	if err := checkConfig(&config); err != nil {
+		return nil, err
+	}
+
+	// Pdeathsig tracer'а привязан к потоку, который его запустил.
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+
+	stdio, err := newStdio(spec)
+	if err != nil {
+		return nil, err
+	}
+	defer stdio.close()
+
+	taskReader, taskWriter, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	defer taskWriter.Close()
+
+	reportReader, reportWriter, err := os.Pipe()
+	if err != nil {
+		taskReader.Close()
+		return nil, err
+	}
+	defer reportReader.Close()
+
+	cmd := reexec.Command(tracerName)
+	cmd.Stdout = s.Log
+	cmd.Stderr = s.Log
+	cmd.SysProcAttr = config.SysProcAttr()
+	cmd.SysProcAttr.Setpgid = true
+	// Tracer и его пространство имен PID не должны пережить вызывающую программу.
+	cmd.SysProcAttr.Pdeathsig = syscall.SIGKILL
+
+	cmd.ExtraFiles = make([]*os.File, stderrFd-reportFd+1)
+	cmd.ExtraFiles[reportFd-3] = reportWriter
+	cmd.ExtraFiles[taskFd-3] = taskReader
+	copy(cmd.ExtraFiles[stdinFd-3:], stdio.files)
+
+	var cg *system.Cgroup
+	if len(config.CgroupPath) > 0 {
+		if cg, err = instance.CreateCgroup(&config); err != nil {
+			closeFiles(cmd.ExtraFiles)
+			return nil, fmt.Errorf("Unable to create cgroup: %v", err)
+		}
+		defer cg.Remove()
+
+		procs, err := cg.OpenProcs()
+		if err != nil {
+			closeFiles(cmd.ExtraFiles)
+			return nil, fmt.Errorf("Unable to open cgroup.procs: %v", err)
+		}
+		cmd.ExtraFiles[cgroupFd-3] = procs
+	}
+
+	err = cmd.Start()
+	closeFiles(cmd.ExtraFiles)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to start tracer: %v", err)
+	}
+	stdio.start()
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			log.Debugf("Run is cancelled, killing tracer group %d\n", cmd.Process.Pid)
+			syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+		case <-done:
+		}
+	}()
+
+	task := &tracerTask{Path: spec.Path, Args: spec.Args, Config: config}
+	err = json.NewEncoder(taskWriter).Encode(task)
+	taskWriter.Close()
+	if err == nil {
+		err = config.StartNetSetGo(cmd.Process.Pid)
+	}
+	if err != nil {
+		syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+		cmd.Wait()
+		return nil, err
+	}
+
+	report, reportErr := instance.ReadReport(reportReader)
+
+	exitCode := 0
+	if err := cmd.Wait(); err != nil {
+		exitError, ok := err.(*exec.ExitError)
+		if !ok {
+			return nil, fmt.Errorf("Error waiting for tracer: %v", err)
+		}
+		exitCode = exitError.ExitCode()
+	}
+	stdio.wait()
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	if report == nil {
+		report = instance.FailedReport(fmt.Errorf("Tracer exited without report (exit code: %d): %v", exitCode, reportErr))
+	}
+
+	if cg != nil {
+		exitCode, err = instance.ApplyCgroupStats(cg, &config, report, exitCode)
+		if err != nil {
+			log.Warnf("Unable to read cgroup stats: %v\n", err)
+		}
+	}
+
+	return &Result{Report: *report, ExitCode: exitCode}, nil
+}
+
+// checkConfig проверяет параметры запуска до старта tracer'а, чтобы вернуть ошибку вызывающему.
+func checkConfig(config *instance.Config) error {
+	if err := config.CheckRootFS(); err != nil {
+		return fmt.Errorf("Unable to locate rootfs directory \"%s\": %v", config.RootFS, err)
+	}
+	if err := config.CheckMounts(); err != nil {
+		return err
+	}
+	if err := config.LoadPolicy(); err != nil {
+		return fmt.Errorf("Unable to load policy: %v", err)
+	}
+	if len(config.NetSetGoPath) > 0 {
+		if err := config.CheckNetSetGoPath(); err != nil {
+			return fmt.Errorf("Unable to locate \"netsetgo\" binary file: %v", err)
+		}
+	}
+	return nil
+}
+
+func closeFiles(files []*os.File) {
+	for _, f := range files {
+		if f != nil {
+			f.Close()
+		}
+	}
+}
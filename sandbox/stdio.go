@@ -0,0 +1,132 @@
+package sandbox
+
+import (
+	"io"
+	"os"
+	"sync"
+	"syscall"
+)
+
+// stdio - стандартные потоки программы. Для потоков, которые не являются файлами,
+// создаются pipe'ы, данные копируются горутинами.
+type stdio struct {
+	// files передаются tracer'у и закрываются после его запуска.
+	files []*os.File
+	// parent - концы pipe'ов, оставшиеся в родительском процессе.
+	parent []*os.File
+
+	copiers []func()
+	wg      sync.WaitGroup
+}
+
+func newStdio(spec *Spec) (*stdio, error) {
+	s := &stdio{}
+
+	if err := s.addInput(spec.Stdin); err != nil {
+		s.close()
+		return nil, err
+	}
+	for _, w := range []io.Writer{spec.Stdout, spec.Stderr} {
+		if err := s.addOutput(w); err != nil {
+			s.close()
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+func (s *stdio) addInput(r io.Reader) error {
+	if r == nil {
+		return s.addDevNull(os.O_RDONLY)
+	}
+	if f, ok := r.(*os.File); ok {
+		return s.addDup(f)
+	}
+
+	pr, pw, err := os.Pipe()
+	if err != nil {
+		return err
+	}
+	s.files = append(s.files, pr)
+	s.parent = append(s.parent, pw)
+
+	// Запись во ввод не ожидается: программа может завершиться, не прочитав его,
+	// а <r> - заблокироваться. Pipe закрывается в close, после чего копирование прерывается.
+	s.copiers = append(s.copiers, func() {
+		io.Copy(pw, r)
+		pw.Close()
+	})
+	return nil
+}
+
+func (s *stdio) addOutput(w io.Writer) error {
+	if w == nil {
+		return s.addDevNull(os.O_WRONLY)
+	}
+	if f, ok := w.(*os.File); ok {
+		return s.addDup(f)
+	}
+
+	pr, pw, err := os.Pipe()
+	if err != nil {
+		return err
+	}
+	s.files = append(s.files, pw)
+	s.parent = append(s.parent, pr)
+
+	s.wg.Add(1)
+	s.copiers = append(s.copiers, func() {
+		defer s.wg.Done()
+		io.Copy(w, pr)
+	})
+	return nil
+}
+
+func (s *stdio) addDevNull(flag int) error {
+	f, err := os.OpenFile(os.DevNull, flag, 0)
+	if err != nil {
+		return err
+	}
+	s.files = append(s.files, f)
+	return nil
+}
+
+// addDup передает tracer'у копию дескриптора <f>, чтобы закрытие после запуска tracer'а
+// не затрагивало файл вызывающего.
+func (s *stdio) addDup(f *os.File) error {
+	fd, err := dup(f)
+	if err != nil {
+		return err
+	}
+	s.files = append(s.files, fd)
+	return nil
+}
+
+// start запускает копирование данных после того, как tracer получил свои концы pipe'ов.
+func (s *stdio) start() {
+	for _, copier := range s.copiers {
+		go copier()
+	}
+}
+
+// wait дожидается окончания копирования вывода программы.
+func (s *stdio) wait() {
+	s.wg.Wait()
+}
+
+func (s *stdio) close() {
+	closeFiles(s.files)
+	closeFiles(s.parent)
+}
+
+func dup(f *os.File) (*os.File, error) {
+	syscall.ForkLock.RLock()
+	defer syscall.ForkLock.RUnlock()
+
+	fd, err := syscall.Dup(int(f.Fd()))
+	if err != nil {
+		return nil, err
+	}
+	syscall.CloseOnExec(fd)
+	return os.NewFile(uintptr(fd), f.Name()), nil
+}
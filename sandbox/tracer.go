@@ -0,0 +1,128 @@
+package sandbox
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"runtime"
+	"syscall"
+
+	"github.com/docker/docker/pkg/reexec"
+	log "github.com/sirupsen/logrus"
+	"github.com/solovev/orange-app-runner/instance"
+)
+
+// tracerName - имя, под которым текущий бинарный файл запускается в роли tracer'а,
+// оно же - hostname в песочнице.
+const tracerName = "oar_sandbox"
+
+// Дескрипторы, которые получает tracer.
+const (
+	// reportFd - отчет tracer'а родительскому процессу.
+	reportFd = 3
+	// cgroupFd - файл "cgroup.procs", в который tracer добавляет tracee.
+	cgroupFd = 4
+	// taskFd - задание tracer'а (tracerTask).
+	taskFd = 5
+	// stdinFd, stdoutFd и stderrFd - стандартные потоки tracee.
+	stdinFd  = 6
+	stdoutFd = 7
+	stderrFd = 8
+)
+
+// tracerTask - задание, которое tracer получает через taskFd.
+type tracerTask struct {
+	Path   string          `json:"path"`
+	Args   []string        `json:"args"`
+	Config instance.Config `json:"config"`
+}
+
+func init() {
+	reexec.Register(tracerName, startTracer)
+}
+
+// Init запускает tracer или executor (instance), если текущий процесс запущен в одной из
+// этих ролей, и возвращает true после их завершения. Программа, использующая Run, должна
+// вызвать Init в начале main и завершиться, если Init вернула true:
+//
+//	if sandbox.Init() {
+//		os.Exit(0)
+//	}
+func Init() bool {
+	return reexec.Init()
+}
+
+func startTracer() {
+	runtime.LockOSThread()
+
+	for fd := reportFd; fd <= stderrFd; fd++ {
+		syscall.CloseOnExec(fd)
+	}
+
+	reportFile := os.NewFile(reportFd, "report")
+	exitCode, report := runTracer()
+
+	if err := report.Write(reportFile); err != nil {
+		log.Warnf("Error sending report to the parent process: %v\n", err)
+	}
+	reportFile.Close()
+
+	log.Infof("Tracer is terminated. Exit code: %d\n", exitCode)
+	os.Exit(exitCode)
+}
+
+func runTracer() (int, *instance.Report) {
+	var task tracerTask
+	taskFile := os.NewFile(taskFd, "task")
+	err := json.NewDecoder(taskFile).Decode(&task)
+	taskFile.Close()
+	if err != nil {
+		return 1, instance.FailedReport(fmt.Errorf("Unable to receive tracer task: %v", err))
+	}
+
+	cfg := &task.Config
+	if cfg.Debug {
+		log.SetLevel(log.DebugLevel)
+	}
+
+	if len(cfg.CgroupPath) > 0 {
+		cfg.CgroupProcs = os.NewFile(cgroupFd, "cgroup.procs")
+		defer cfg.CgroupProcs.Close()
+	}
+
+	cfg.Stdio = []*os.File{
+		os.NewFile(stdinFd, "stdin"),
+		os.NewFile(stdoutFd, "stdout"),
+		os.NewFile(stderrFd, "stderr"),
+	}
+
+	if err := cfg.LoadPolicy(); err != nil {
+		return 1, instance.FailedReport(fmt.Errorf("Unable to load policy: %v", err))
+	}
+
+	if err := cfg.OpenStats(); err != nil {
+		return 1, instance.FailedReport(err)
+	}
+	if cfg.StatsFile != nil {
+		defer cfg.StatsFile.Close()
+	}
+
+	if err := cfg.OpenSyscallLog(); err != nil {
+		return 1, instance.FailedReport(err)
+	}
+	if cfg.SyscallLogFile != nil {
+		defer cfg.SyscallLogFile.Close()
+	}
+
+	releaseMounts, err := cfg.SetupNamespaces(tracerName)
+	if err != nil {
+		return 1, instance.FailedReport(err)
+	}
+	defer releaseMounts()
+
+	exitCode, report, err := instance.Run(task.Path, task.Args, cfg)
+	if err != nil {
+		log.Warnf("Error running tracee process: %v\n", err)
+	}
+	return exitCode, report
+}
@@ -0,0 +1,508 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/jessevdk/go-flags"
+	log "github.com/sirupsen/logrus"
+	"github.com/solovev/orange-app-runner/instance"
+	"github.com/solovev/orange-app-runner/sandbox"
+)
+
+// serveOptions - параметры режима "oar serve". Остальные параметры командной строки oar
+// задают конфигурацию запусков по умолчанию.
+type serveOptions struct {
+	Socket   string `long:"socket" description:"Set path to the Unix socket to listen on" default:"/run/oar.sock"`
+	Workers  int    `long:"workers" description:"Set number of runs executed concurrently (by default, number of CPUs)"`
+	MaxQueue int    `long:"max-queue" description:"Reject requests when the specified number of runs is waiting in the queue (0 - unlimited)"`
+	Jail     string `long:"jail" description:"Set path to the directory that stdin, stdout and stderr paths of requests are relative to (without it requests cannot redirect streams to files)"`
+}
+
+// Типы запросов и событий протокола "oar serve": запросы и ответы - JSON объекты, по одному в строке.
+const (
+	serveRequestRun    = "run"
+	serveRequestStatus = "status"
+
+	serveEventQueued  = "queued"
+	serveEventStarted = "started"
+	serveEventResult  = "result"
+	serveEventError   = "error"
+	serveEventStatus  = "status"
+)
+
+// serveLimits - ограничения запуска в тех же единицах, что и параметры командной строки.
+// Не указанные в запросе ограничения берутся из параметров "oar serve".
+type serveLimits struct {
+	RealTimeLimit int64   `json:"rt_limit"`
+	CPUTimeLimit  float64 `json:"cput_limit"`
+	MemoryLimit   int64   `json:"mem_limit"`
+	OutputLimit   int64   `json:"output_limit"`
+	IdleLimit     int64   `json:"idle_limit"`
+	ProcessLimit  int64   `json:"pids_limit"`
+}
+
+// serveRequest - запрос клиента. Для запуска (<Type> "run" или пустой) указываются программа,
+// ее аргументы, ограничения и пути к файлам стандартных потоков на стороне демона.
+type serveRequest struct {
+	ID   string `json:"id"`
+	Type string `json:"type"`
+
+	Binary string      `json:"binary"`
+	Args   []string    `json:"args"`
+	Env    []string    `json:"env"`
+	Dir    string      `json:"dir"`
+	Limits serveLimits `json:"limits"`
+
+	Stdin  string `json:"stdin"`
+	Stdout string `json:"stdout"`
+	Stderr string `json:"stderr"`
+}
+
+// serveEvent - ответ демона. События запуска: "queued" (с глубиной очереди), "started",
+// "result" (с отчетом) или "error"; на запрос "status" - событие "status".
+type serveEvent struct {
+	ID    string `json:"id,omitempty"`
+	Event string `json:"event"`
+
+	QueueDepth *int64 `json:"queue_depth,omitempty"`
+	Running    *int64 `json:"running,omitempty"`
+	Workers    int    `json:"workers,omitempty"`
+
+	Result *sandbox.Result `json:"result,omitempty"`
+	Error  string          `json:"error,omitempty"`
+}
+
+// serveJob - запуск, ожидающий свободного обработчика.
+type serveJob struct {
+	ctx     context.Context
+	request *serveRequest
+	conn    *serveConn
+}
+
+// server принимает запросы и выполняет запуски пулом из <workers> обработчиков.
+type server struct {
+	base     instance.Config
+	workers  int
+	maxQueue int64
+	// jail - каталог с раскрытыми символическими ссылками, внутри которого демон открывает
+	// файлы стандартных потоков запусков. Пустой - файлы потоков запрещены.
+	jail string
+
+	sandbox *sandbox.Sandbox
+	queue   *serveQueue
+
+	running int64
+	nextID  uint64
+}
+
+// serveQueue - очередь запусков в порядке поступления.
+type serveQueue struct {
+	mu     sync.Mutex
+	cond   *sync.Cond
+	jobs   []*serveJob
+	closed bool
+}
+
+func newServeQueue() *serveQueue {
+	q := &serveQueue{}
+	q.cond = sync.NewCond(&q.mu)
+	return q
+}
+
+// push добавляет запуск в очередь и возвращает ее длину или false, если в очереди
+// уже <limit> запусков (0 - без ограничения).
+func (q *serveQueue) push(job *serveJob, limit int64) (int64, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if limit > 0 && int64(len(q.jobs)) >= limit {
+		return int64(len(q.jobs)), false
+	}
+	q.jobs = append(q.jobs, job)
+	q.cond.Signal()
+	return int64(len(q.jobs)), true
+}
+
+// pop ждет запуск из очереди, после закрытия пустой очереди возвращает nil.
+func (q *serveQueue) pop() *serveJob {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for len(q.jobs) == 0 && !q.closed {
+		q.cond.Wait()
+	}
+	if len(q.jobs) == 0 {
+		return nil
+	}
+
+	job := q.jobs[0]
+	q.jobs[0] = nil
+	q.jobs = q.jobs[1:]
+	return job
+}
+
+func (q *serveQueue) len() int64 {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return int64(len(q.jobs))
+}
+
+func (q *serveQueue) close() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.closed = true
+	q.cond.Broadcast()
+}
+
+// aLongTimeAgo - срок чтения, прерывающий ожидание запроса при остановке демона.
+var aLongTimeAgo = time.Unix(1, 0)
+
+// serveConn - соединение с клиентом. Ответы разных запусков пишутся по мере готовности.
+type serveConn struct {
+	conn    net.Conn
+	cancel  context.CancelFunc
+	mu      sync.Mutex
+	encoder *json.Encoder
+	pending sync.WaitGroup
+}
+
+// runServe реализует режим "oar serve --socket <path> [<options>]".
+func runServe(args []string) int {
+	var serveCfg serveOptions
+
+	parser := flags.NewParser(&cfg, flags.Default)
+	parser.Usage = "serve --socket <path> [<options>]"
+	if _, err := parser.AddGroup("Serve mode", "", &serveCfg); err != nil {
+		log.Fatalln(err)
+	}
+
+	rest, err := parser.ParseArgs(args)
+	if err != nil {
+		if flagsErr, ok := err.(*flags.Error); ok && flagsErr.Type == flags.ErrHelp {
+			return 0
+		}
+		return 1
+	}
+	if len(rest) > 0 {
+		log.Errorf("Unexpected arguments in serve mode: %v\n", rest)
+		return 1
+	}
+
+	log.SetFormatter(&log.TextFormatter{})
+	if cfg.Debug {
+		log.SetLevel(log.DebugLevel)
+	}
+	checkConfig()
+
+	s := &server{
+		base:     cfg,
+		workers:  serveCfg.Workers,
+		maxQueue: int64(serveCfg.MaxQueue),
+		sandbox:  &sandbox.Sandbox{},
+		queue:    newServeQueue(),
+	}
+	if s.workers <= 0 {
+		s.workers = runtime.NumCPU()
+	}
+	if len(serveCfg.Jail) > 0 {
+		jail, err := filepath.Abs(serveCfg.Jail)
+		if err == nil {
+			jail, err = filepath.EvalSymlinks(jail)
+		}
+		if err != nil {
+			log.Errorf("Invalid jail directory \"%s\": %v\n", serveCfg.Jail, err)
+			return 1
+		}
+		s.jail = jail
+	}
+	if cfg.Debug {
+		s.sandbox.Log = os.Stderr
+	}
+
+	if err := s.listen(serveCfg.Socket); err != nil {
+		log.Errorf("Unable to serve on \"%s\": %v\n", serveCfg.Socket, err)
+		return 1
+	}
+	return 0
+}
+
+// listen принимает соединения на <socket>, пока не будет получен SIGINT или SIGTERM.
+func (s *server) listen(socket string) error {
+	if err := os.Remove(socket); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(socket)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		sig := <-signals
+		log.Infof("Received %v, shutting down...\n", sig)
+		cancel()
+		listener.Close()
+	}()
+
+	workers := &sync.WaitGroup{}
+	for i := 0; i < s.workers; i++ {
+		workers.Add(1)
+		go s.work(workers)
+	}
+	log.Infof("Listening on \"%s\" with %d workers\n", socket, s.workers)
+
+	conns := &sync.WaitGroup{}
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				break
+			}
+			log.Warnf("Error accepting connection: %v\n", err)
+			continue
+		}
+
+		conns.Add(1)
+		go func() {
+			defer conns.Done()
+			s.handle(ctx, conn)
+		}()
+	}
+
+	conns.Wait()
+	s.queue.close()
+	workers.Wait()
+	return nil
+}
+
+// handle читает запросы клиента до конца потока и дожидается завершения его запусков.
+// Если клиент закрыл соединение или отключился (ответ не удалось отправить), его запуски,
+// в том числе ожидающие в очереди, отменяются.
+func (s *server) handle(ctx context.Context, conn net.Conn) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	c := &serveConn{conn: conn, cancel: cancel, encoder: json.NewEncoder(conn)}
+	defer conn.Close()
+
+	go func() {
+		<-ctx.Done()
+		conn.SetReadDeadline(aLongTimeAgo)
+	}()
+
+	decoder := json.NewDecoder(conn)
+	for {
+		request := &serveRequest{Limits: s.defaultLimits()}
+		if err := decoder.Decode(request); err != nil {
+			if err != io.EOF && ctx.Err() == nil {
+				log.Warnf("Error reading request: %v\n", err)
+				c.send(&serveEvent{Event: serveEventError, Error: err.Error()})
+			}
+			cancel()
+			break
+		}
+		if len(request.ID) == 0 {
+			request.ID = strconv.FormatUint(atomic.AddUint64(&s.nextID, 1), 10)
+		}
+
+		switch request.Type {
+		case serveRequestRun, "":
+			s.enqueue(ctx, request, c)
+		case serveRequestStatus:
+			c.send(s.status(request.ID))
+		default:
+			c.send(&serveEvent{ID: request.ID, Event: serveEventError, Error: fmt.Sprintf("Unknown request type \"%s\"", request.Type)})
+		}
+	}
+
+	c.pending.Wait()
+}
+
+// enqueue ставит запуск в очередь, если она не переполнена.
+func (s *server) enqueue(ctx context.Context, request *serveRequest, c *serveConn) {
+	if len(request.Binary) == 0 {
+		c.send(&serveEvent{ID: request.ID, Event: serveEventError, Error: "Binary is not specified"})
+		return
+	}
+
+	c.pending.Add(1)
+	depth, ok := s.queue.push(&serveJob{ctx: ctx, request: request, conn: c}, s.maxQueue)
+	if !ok {
+		c.pending.Done()
+		c.send(&serveEvent{ID: request.ID, Event: serveEventError, Error: "Queue is full"})
+		return
+	}
+	c.send(&serveEvent{ID: request.ID, Event: serveEventQueued, QueueDepth: &depth})
+}
+
+func (s *server) work(wg *sync.WaitGroup) {
+	defer wg.Done()
+
+	for {
+		job := s.queue.pop()
+		if job == nil {
+			return
+		}
+
+		atomic.AddInt64(&s.running, 1)
+		s.run(job)
+		atomic.AddInt64(&s.running, -1)
+		job.conn.pending.Done()
+	}
+}
+
+func (s *server) run(job *serveJob) {
+	request, c := job.request, job.conn
+	if job.ctx.Err() != nil {
+		// Клиент отключился, пока запуск ждал в очереди.
+		log.Infof("Run %s: cancelled\n", request.ID)
+		return
+	}
+	c.send(&serveEvent{ID: request.ID, Event: serveEventStarted})
+	log.Infof("Run %s: %s %v\n", request.ID, request.Binary, request.Args)
+
+	result, err := s.runRequest(job.ctx, request)
+	if err != nil {
+		log.Warnf("Run %s failed: %v\n", request.ID, err)
+		c.send(&serveEvent{ID: request.ID, Event: serveEventError, Error: err.Error()})
+		return
+	}
+
+	log.Infof("Run %s: %s\n", request.ID, result.Verdict)
+	c.send(&serveEvent{ID: request.ID, Event: serveEventResult, Result: result})
+}
+
+// runRequest открывает файлы стандартных потоков и запускает программу в песочнице.
+func (s *server) runRequest(ctx context.Context, request *serveRequest) (*sandbox.Result, error) {
+	config := s.base
+	config.RealTimeLimit = request.Limits.RealTimeLimit
+	config.CPUTimeLimit = request.Limits.CPUTimeLimit
+	config.MemoryLimit = request.Limits.MemoryLimit
+	config.OutputLimit = request.Limits.OutputLimit
+	config.IdleLimit = request.Limits.IdleLimit
+	config.ProcessLimit = request.Limits.ProcessLimit
+	if request.Env != nil {
+		config.Env = request.Env
+	}
+	if len(request.Dir) > 0 {
+		config.WorkingDir = request.Dir
+	}
+
+	spec := &sandbox.Spec{
+		Path:   request.Binary,
+		Args:   request.Args,
+		Config: config,
+	}
+
+	var files []*os.File
+	defer func() {
+		for _, f := range files {
+			f.Close()
+		}
+	}()
+
+	streams := []struct {
+		path string
+		flag int
+		set  func(f *os.File)
+	}{
+		{request.Stdin, os.O_RDONLY, func(f *os.File) { spec.Stdin = f }},
+		{request.Stdout, os.O_WRONLY | os.O_CREATE | os.O_TRUNC, func(f *os.File) { spec.Stdout = f }},
+		{request.Stderr, os.O_WRONLY | os.O_CREATE | os.O_TRUNC, func(f *os.File) { spec.Stderr = f }},
+	}
+	for _, stream := range streams {
+		if len(stream.path) == 0 {
+			continue
+		}
+		path, err := s.jailPath(stream.path)
+		if err != nil {
+			return nil, err
+		}
+		f, err := os.OpenFile(path, stream.flag|syscall.O_NOFOLLOW, 0644)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to open \"%s\": %v", stream.path, err)
+		}
+		files = append(files, f)
+		stream.set(f)
+	}
+
+	return s.sandbox.Run(ctx, spec)
+}
+
+// jailPath возвращает путь к файлу потока <path> внутри каталога "--jail". Файлы открываются
+// демоном с его правами, поэтому абсолютные пути и выход из каталога (через ".." или
+// символические ссылки) запрещены.
+func (s *server) jailPath(path string) (string, error) {
+	if len(s.jail) == 0 {
+		return "", fmt.Errorf("Unable to open \"%s\": stream files require \"--jail\"", path)
+	}
+	clean := filepath.Clean(path)
+	if filepath.IsAbs(clean) || clean == "." || clean == ".." || strings.HasPrefix(clean, "../") {
+		return "", fmt.Errorf("Stream path \"%s\" must be relative to the jail directory", path)
+	}
+
+	full := filepath.Join(s.jail, clean)
+	dir, err := filepath.EvalSymlinks(filepath.Dir(full))
+	if err != nil {
+		return "", fmt.Errorf("Unable to open \"%s\": %v", path, err)
+	}
+	if s.jail != "/" && dir != s.jail && !strings.HasPrefix(dir, s.jail+"/") {
+		return "", fmt.Errorf("Stream path \"%s\" is outside of the jail directory", path)
+	}
+	return filepath.Join(dir, filepath.Base(full)), nil
+}
+
+func (s *server) defaultLimits() serveLimits {
+	return serveLimits{
+		RealTimeLimit: s.base.RealTimeLimit,
+		CPUTimeLimit:  s.base.CPUTimeLimit,
+		MemoryLimit:   s.base.MemoryLimit,
+		OutputLimit:   s.base.OutputLimit,
+		IdleLimit:     s.base.IdleLimit,
+		ProcessLimit:  s.base.ProcessLimit,
+	}
+}
+
+func (s *server) status(id string) *serveEvent {
+	queued := s.queue.len()
+	running := atomic.LoadInt64(&s.running)
+	return &serveEvent{
+		ID:         id,
+		Event:      serveEventStatus,
+		QueueDepth: &queued,
+		Running:    &running,
+		Workers:    s.workers,
+	}
+}
+
+// send пишет событие клиенту. Ошибка записи означает, что клиент отключился,
+// поэтому его запуски отменяются.
+func (c *serveConn) send(event *serveEvent) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := c.encoder.Encode(event); err != nil {
+		log.Debugf("Unable to send event \"%s\" of run %s: %v\n", event.Event, event.ID, err)
+		c.cancel()
+	}
+}
@@ -0,0 +1,207 @@
+package system
+
+import (
+	"debug/elf"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+)
+
+// maxStackFrames - наибольшее число кадров, которое возвращает GetBacktrace.
+const maxStackFrames = 64
+
+// maxSymbolFileSize - наибольший размер файла, таблица символов которого читается.
+const maxSymbolFileSize = 64 << 20
+
+// StackFrame - кадр стека остановленного процесса.
+type StackFrame struct {
+	PC uint64
+	// Module - отображенный файл, которому принадлежит адрес, и смещение адреса в этом файле.
+	Module string
+	Offset uint64
+	// Function - имя функции из таблицы символов ELF и смещение адреса от ее начала.
+	Function       string
+	FunctionOffset uint64
+}
+
+func (f *StackFrame) String() string {
+	result := fmt.Sprintf("0x%x", f.PC)
+	if len(f.Function) > 0 {
+		result += fmt.Sprintf(" %s+0x%x", f.Function, f.FunctionOffset)
+	}
+	if len(f.Module) > 0 {
+		result += fmt.Sprintf(" (%s+0x%x)", filepath.Base(f.Module), f.Offset)
+	}
+	return result
+}
+
+// GetBacktrace раскручивает стек процесса <pid>, остановленного ptrace, по цепочке указателей
+// кадров и определяет для каждого адреса модуль и функцию. <procfsPid> - номер процесса в /proc.
+// Для кода, собранного без указателей кадров, возвращается только первый кадр.
+// Файлы внутри каталога <untrusted> мог создать сам процесс, поэтому имена функций в них не ищутся.
+func GetBacktrace(pid, procfsPid int, untrusted string) ([]StackFrame, error) {
+	var regs syscall.PtraceRegs
+	if err := syscall.PtraceGetRegs(pid, &regs); err != nil {
+		return nil, err
+	}
+	mappings, err := GetMemoryMappings(procfsPid)
+	if err != nil {
+		return nil, err
+	}
+
+	pc, fp := framePointerFromRegs(&regs)
+	pcs := []uint64{pc}
+	for fp != 0 && len(pcs) < maxStackFrames {
+		// Кадр: [fp] - указатель кадра вызывающей функции, [fp + 8] - адрес возврата.
+		if m := FindMapping(mappings, fp); m == nil || !strings.Contains(m.Perms, "w") || fp%8 != 0 {
+			break
+		}
+		var frame [16]byte
+		if _, err := syscall.PtracePeekData(pid, uintptr(fp), frame[:]); err != nil {
+			break
+		}
+		next := binary.LittleEndian.Uint64(frame[0:])
+		ret := binary.LittleEndian.Uint64(frame[8:])
+		if m := FindMapping(mappings, ret); m == nil || !strings.Contains(m.Perms, "x") {
+			break
+		}
+		pcs = append(pcs, ret)
+
+		// Стек растет вниз, поэтому кадры вызывающих функций находятся выше.
+		if next <= fp {
+			break
+		}
+		fp = next
+	}
+
+	symbols := make(map[string]*symbolTable)
+	frames := make([]StackFrame, len(pcs))
+	for i, pc := range pcs {
+		frames[i].PC = pc
+		m := FindMapping(mappings, pc)
+		if m == nil || !strings.HasPrefix(m.Path, "/") {
+			continue
+		}
+		frames[i].Module = m.Path
+		frames[i].Offset = pc - m.Start + m.Offset
+
+		if len(untrusted) > 0 && (m.Path == untrusted || strings.HasPrefix(m.Path, untrusted+"/")) {
+			continue
+		}
+
+		table, ok := symbols[m.Path]
+		if !ok {
+			// Файл без таблицы символов (или недоступный) оставляет кадр без имени функции.
+			table, _ = loadSymbolTable(procfsPid, m.Path)
+			symbols[m.Path] = table
+		}
+		// Адрес возврата указывает на инструкцию после вызова, которая может относиться
+		// к следующей функции.
+		lookup := frames[i].Offset
+		if i > 0 {
+			lookup--
+		}
+		if table != nil {
+			frames[i].Function, frames[i].FunctionOffset = table.find(lookup)
+			if i > 0 && len(frames[i].Function) > 0 {
+				frames[i].FunctionOffset++
+			}
+		}
+	}
+	return frames, nil
+}
+
+// symbolTable - функции ELF файла, упорядоченные по адресу.
+type symbolTable struct {
+	progs   []*elf.Prog
+	symbols []elf.Symbol
+}
+
+// loadSymbolTable читает таблицу символов файла <path>, отображенного в память процесса
+// <procfsPid>. Путь сначала ищется в корневой файловой системе процесса. Файл мог быть
+// подменен процессом, поэтому читаются только обычные файлы не больше maxSymbolFileSize,
+// а ошибки разбора ELF не завершают tracer.
+func loadSymbolTable(procfsPid int, path string) (table *symbolTable, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			table, err = nil, fmt.Errorf("Unable to parse ELF file \"%s\": %v", path, r)
+		}
+	}()
+
+	// O_NONBLOCK не дает зависнуть на FIFO, подложенном вместо файла.
+	f, err := os.OpenFile(fmt.Sprintf("/proc/%d/root%s", procfsPid, path), os.O_RDONLY|syscall.O_NONBLOCK, 0)
+	if err != nil {
+		if f, err = os.OpenFile(path, os.O_RDONLY|syscall.O_NONBLOCK, 0); err != nil {
+			return nil, err
+		}
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if !info.Mode().IsRegular() || info.Size() > maxSymbolFileSize {
+		return nil, fmt.Errorf("File \"%s\" is not a regular file of at most %d bytes", path, maxSymbolFileSize)
+	}
+
+	file, err := elf.NewFile(f)
+	if err != nil {
+		return nil, err
+	}
+
+	table = &symbolTable{}
+	for _, prog := range file.Progs {
+		if prog.Type == elf.PT_LOAD {
+			table.progs = append(table.progs, prog)
+		}
+	}
+
+	symbols, _ := file.Symbols()
+	dynamic, _ := file.DynamicSymbols()
+	for _, symbol := range append(symbols, dynamic...) {
+		if elf.ST_TYPE(symbol.Info) == elf.STT_FUNC && symbol.Value != 0 && len(symbol.Name) > 0 {
+			table.symbols = append(table.symbols, symbol)
+		}
+	}
+	if len(table.symbols) == 0 {
+		return nil, errors.New("No function symbols")
+	}
+	sort.Slice(table.symbols, func(i, j int) bool {
+		return table.symbols[i].Value < table.symbols[j].Value
+	})
+	return table, nil
+}
+
+// find возвращает функцию, содержащую смещение <offset> в файле, и смещение от ее начала.
+func (t *symbolTable) find(offset uint64) (string, uint64) {
+	// Символы содержат виртуальные адреса, смещение в файле переводится через сегмент.
+	addr, found := uint64(0), false
+	for _, prog := range t.progs {
+		if offset >= prog.Off && offset < prog.Off+prog.Filesz {
+			addr, found = offset-prog.Off+prog.Vaddr, true
+			break
+		}
+	}
+	if !found {
+		return "", 0
+	}
+
+	// Ближайшие символы перед адресом могут быть псевдонимами без размера, поэтому
+	// проверяются несколько из них.
+	i := sort.Search(len(t.symbols), func(i int) bool {
+		return t.symbols[i].Value > addr
+	})
+	for j := i - 1; j >= 0 && j >= i-8; j-- {
+		symbol := t.symbols[j]
+		if addr < symbol.Value+symbol.Size {
+			return symbol.Name, addr - symbol.Value
+		}
+	}
+	return "", 0
+}
@@ -0,0 +1,123 @@
+package system
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// Cgroup представляет директорию cgroup v2.
+type Cgroup struct {
+	Path string
+}
+
+// CreateCgroup создает дочернюю cgroup <name> в директории <parent>,
+// предварительно включив в <parent> контроллеры <controllers>.
+func CreateCgroup(parent, name string, controllers []string) (*Cgroup, error) {
+	parent, err := filepath.Abs(parent)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := os.Stat(filepath.Join(parent, "cgroup.controllers")); err != nil {
+		return nil, fmt.Errorf("\"%s\" is not a cgroup v2 directory: %v", parent, err)
+	}
+
+	parentGroup := &Cgroup{Path: parent}
+	for _, controller := range controllers {
+		if err := parentGroup.Set("cgroup.subtree_control", "+"+controller); err != nil {
+			return nil, fmt.Errorf("Unable to enable \"%s\" controller in \"%s\": %v", controller, parent, err)
+		}
+	}
+
+	path := filepath.Join(parent, name)
+	if err := os.Mkdir(path, 0755); err != nil {
+		return nil, err
+	}
+	return &Cgroup{Path: path}, nil
+}
+
+// Set записывает значение <value> в файл <file> cgroup.
+func (c *Cgroup) Set(file, value string) error {
+	return ioutil.WriteFile(filepath.Join(c.Path, file), []byte(value), 0644)
+}
+
+// OpenProcs открывает файл "cgroup.procs" на запись, чтобы процесс, получивший
+// дескриптор, мог добавить в cgroup процесс из своего pid namespace.
+func (c *Cgroup) OpenProcs() (*os.File, error) {
+	return os.OpenFile(filepath.Join(c.Path, "cgroup.procs"), os.O_WRONLY, 0)
+}
+
+// Stat читает файл <file> формата "ключ значение" (memory.events, cpu.stat и т.д.).
+func (c *Cgroup) Stat(file string) (map[string]uint64, error) {
+	f, err := os.Open(filepath.Join(c.Path, file))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	result := make(map[string]uint64)
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		fields := strings.Fields(sc.Text())
+		if len(fields) != 2 {
+			continue
+		}
+		value, err := strconv.ParseUint(fields[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to parse \"%s\" from \"%s\" file: %v", fields[0], file, err)
+		}
+		result[fields[0]] = value
+	}
+	return result, sc.Err()
+}
+
+// Value читает файл <file>, содержащий одно число.
+func (c *Cgroup) Value(file string) (uint64, error) {
+	data, err := ioutil.ReadFile(filepath.Join(c.Path, file))
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseUint(strings.TrimSpace(string(data)), 10, 64)
+}
+
+// Remove завершает все оставшиеся в cgroup процессы и удаляет ее директорию.
+func (c *Cgroup) Remove() error {
+	if err := c.Set("cgroup.kill", "1"); err != nil {
+		c.killProcs()
+	}
+
+	var err error
+	for i := 0; i < 50; i++ {
+		if err = os.Remove(c.Path); err == nil || os.IsNotExist(err) {
+			return nil
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return err
+}
+
+// killProcs посылает SIGKILL процессам из "cgroup.procs" (для ядер без "cgroup.kill").
+func (c *Cgroup) killProcs() {
+	data, err := ioutil.ReadFile(filepath.Join(c.Path, "cgroup.procs"))
+	if err != nil {
+		return
+	}
+	for _, line := range strings.Fields(string(data)) {
+		if pid, err := strconv.Atoi(line); err == nil {
+			syscall.Kill(pid, syscall.SIGKILL)
+		}
+	}
+}
+
+// AttachToCgroup добавляет процесс <pid> в cgroup через открытый файл "cgroup.procs".
+func AttachToCgroup(procs *os.File, pid int) error {
+	_, err := procs.Write([]byte(strconv.Itoa(pid)))
+	return err
+}
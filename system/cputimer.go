@@ -0,0 +1,87 @@
+package system
+
+import (
+	"syscall"
+	"time"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+const (
+	// cpuClockSched - часы процессорного времени (CPUCLOCK_SCHED) в идентификаторе часов процесса.
+	cpuClockSched = 2
+
+	sigevSignal  = 0 // SIGEV_SIGNAL
+	timerAbstime = 1 // TIMER_ABSTIME
+
+	// sigeventSize - размер struct sigevent в ядре.
+	sigeventSize = 64
+)
+
+// sigevent - struct sigevent для уведомления сигналом.
+type sigevent struct {
+	value  uintptr
+	signo  int32
+	notify int32
+	_      [sigeventSize - 8 - unsafe.Sizeof(uintptr(0))]byte
+}
+
+type itimerspec struct {
+	interval unix.Timespec
+	value    unix.Timespec
+}
+
+// ProcessCPUClock возвращает идентификатор часов процессорного времени процесса <pid>
+// (сумма по всем его потокам), как clock_getcpuclockid(3).
+func ProcessCPUClock(pid int) int32 {
+	return int32(^pid<<3 | cpuClockSched)
+}
+
+// GetProcessCPUTime возвращает время процессора, использованное процессом <pid> (без потомков),
+// с точностью до наносекунд. Номер процесса - в пространстве имен PID текущего процесса.
+func GetProcessCPUTime(pid int) (time.Duration, error) {
+	var ts unix.Timespec
+	if err := unix.ClockGettime(ProcessCPUClock(pid), &ts); err != nil {
+		return 0, err
+	}
+	return time.Duration(ts.Nano()), nil
+}
+
+// CPUTimer - POSIX таймер на часах процессорного времени другого процесса. При срабатывании
+// ядро отправляет текущему процессу сигнал, указанный при создании.
+type CPUTimer struct {
+	id int32
+}
+
+// NewCPUTimer создает неактивный таймер на часах процесса <pid>, который отправляет сигнал <signal>.
+func NewCPUTimer(pid int, signal syscall.Signal) (*CPUTimer, error) {
+	event := sigevent{signo: int32(signal), notify: sigevSignal}
+	timer := &CPUTimer{}
+	_, _, errno := syscall.Syscall(unix.SYS_TIMER_CREATE, uintptr(ProcessCPUClock(pid)),
+		uintptr(unsafe.Pointer(&event)), uintptr(unsafe.Pointer(&timer.id)))
+	if errno != 0 {
+		return nil, errno
+	}
+	return timer, nil
+}
+
+// Set запускает таймер до момента, когда время процессора процесса достигнет <expires>.
+func (t *CPUTimer) Set(expires time.Duration) error {
+	spec := itimerspec{value: unix.NsecToTimespec(expires.Nanoseconds())}
+	_, _, errno := syscall.Syscall6(unix.SYS_TIMER_SETTIME, uintptr(t.id), timerAbstime,
+		uintptr(unsafe.Pointer(&spec)), 0, 0, 0)
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// Delete удаляет таймер.
+func (t *CPUTimer) Delete() error {
+	_, _, errno := syscall.Syscall(unix.SYS_TIMER_DELETE, uintptr(t.id), 0, 0)
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
@@ -0,0 +1,73 @@
+package system
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"strings"
+)
+
+// MemoryMapping - область памяти процесса из "/proc/<pid>/maps".
+type MemoryMapping struct {
+	Start uint64
+	End   uint64
+	// Perms - права доступа ("r-xp").
+	Perms  string
+	Offset uint64
+	// Path - отображенный файл или псевдо-имя ("[stack]", "[heap]"); пусто для анонимной памяти.
+	Path string
+}
+
+// Contains возвращает true, если адрес <addr> принадлежит области.
+func (m *MemoryMapping) Contains(addr uint64) bool {
+	return addr >= m.Start && addr < m.End
+}
+
+// GetMemoryMappings возвращает области памяти процесса <pid> (номер процесса - как в /proc)
+// в порядке возрастания адресов.
+func GetMemoryMappings(pid int) ([]MemoryMapping, error) {
+	path := fmt.Sprintf("/proc/%d/maps", pid)
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var result []MemoryMapping
+	for _, line := range strings.Split(string(data), "\n") {
+		// Формат: "<start>-<end> <perms> <offset> <dev> <inode> [<path>]".
+		fields := strings.Fields(line)
+		if len(fields) < 5 {
+			continue
+		}
+
+		bounds := strings.SplitN(fields[0], "-", 2)
+		if len(bounds) != 2 {
+			return nil, fmt.Errorf("Unable to parse \"%s\" file: %s", path, line)
+		}
+		m := MemoryMapping{Perms: fields[1]}
+		if m.Start, err = strconv.ParseUint(bounds[0], 16, 64); err != nil {
+			return nil, fmt.Errorf("Unable to parse \"%s\" file: %v", path, err)
+		}
+		if m.End, err = strconv.ParseUint(bounds[1], 16, 64); err != nil {
+			return nil, fmt.Errorf("Unable to parse \"%s\" file: %v", path, err)
+		}
+		if m.Offset, err = strconv.ParseUint(fields[2], 16, 64); err != nil {
+			return nil, fmt.Errorf("Unable to parse \"%s\" file: %v", path, err)
+		}
+		if len(fields) > 5 {
+			m.Path = strings.Join(fields[5:], " ")
+		}
+		result = append(result, m)
+	}
+	return result, nil
+}
+
+// FindMapping возвращает область из <mappings>, которой принадлежит адрес <addr>, или nil.
+func FindMapping(mappings []MemoryMapping, addr uint64) *MemoryMapping {
+	for i := range mappings {
+		if mappings[i].Contains(addr) {
+			return &mappings[i]
+		}
+	}
+	return nil
+}
@@ -138,71 +138,103 @@ func GetTotalCPUTime() (uint64, error) {
 	return user + nice + system + idle, nil
 }
 
-// GetProcessStats возвращает количество времени занимаемое процессом <pid> в CPU и занимаемую им виртуальную память.
-func GetProcessStats(pid int) (uint64, uint64, error) {
-	path := fmt.Sprintf("/proc/%d/stat", pid)
-	data, err := ioutil.ReadFile(path)
+// ProcfsPid возвращает номер, под которым процесс <pid> из текущего пространства имен PID виден
+// в /proc. Если /proc смонтирован в родительском пространстве имен (tracer запущен без корневой ФС),
+// номера отличаются, и процесс ищется по последнему значению "NSpid" среди процессов того же
+// пространства имен.
+func ProcfsPid(pid int) (int, error) {
+	self, err := readNSpid("self")
 	if err != nil {
-		return 0, 0, err
+		return 0, err
 	}
-	stats := strings.Fields(string(data))
-
-	utime, err := strconv.ParseUint(stats[13], 10, 64)
-	if err != nil {
-		return 0, 0, fmt.Errorf("Unable to parse \"utime\" from \"%s\" file: %v", path, err)
+	if len(self) <= 1 {
+		return pid, nil
 	}
-	stime, err := strconv.ParseUint(stats[14], 10, 64)
+
+	ns, err := os.Readlink("/proc/self/ns/pid")
 	if err != nil {
-		return 0, 0, fmt.Errorf("Unable to parse \"stime\" from \"%s\" file: %v", path, err)
+		return 0, err
 	}
-	cutime, err := strconv.ParseUint(stats[15], 10, 64)
+
+	entries, err := ioutil.ReadDir("/proc")
 	if err != nil {
-		return 0, 0, fmt.Errorf("Unable to parse \"cutime\" from \"%s\" file: %v", path, err)
+		return 0, err
 	}
-	cstime, err := strconv.ParseUint(stats[16], 10, 64)
-	if err != nil {
-		return 0, 0, fmt.Errorf("Unable to parse \"cstime\" from \"%s\" file: %v", path, err)
+	for _, entry := range entries {
+		if _, err := strconv.Atoi(entry.Name()); err != nil {
+			continue
+		}
+
+		nspid, err := readNSpid(entry.Name())
+		if err != nil || len(nspid) != len(self) || nspid[len(nspid)-1] != pid {
+			continue
+		}
+		if link, err := os.Readlink("/proc/" + entry.Name() + "/ns/pid"); err == nil && link == ns {
+			return nspid[0], nil
+		}
 	}
+	return 0, fmt.Errorf("Process %d is not found in /proc", pid)
+}
 
-	vsize, err := strconv.ParseUint(stats[22], 10, 64)
+// readNSpid возвращает номера процесса <name> ("self" или pid в /proc) во вложенных пространствах имен PID.
+func readNSpid(name string) ([]int, error) {
+	data, err := ioutil.ReadFile("/proc/" + name + "/status")
 	if err != nil {
-		return 0, 0, fmt.Errorf("Unable to parse \"vtime\" from \"%s\" file: %v", path, err)
+		return nil, err
 	}
-	return stime + utime + cutime + cstime, vsize, nil
-}
 
-func readLine(r io.Reader, lineNum int) (line string, lastLine int, err error) {
-	sc := bufio.NewScanner(r)
-	for sc.Scan() {
-		lastLine++
-		if lastLine == lineNum {
-			return sc.Text(), lastLine, sc.Err()
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 || fields[0] != "NSpid:" {
+			continue
+		}
+
+		var result []int
+		for _, field := range fields[1:] {
+			value, err := strconv.Atoi(field)
+			if err != nil {
+				return nil, fmt.Errorf("Unable to parse \"NSpid\" of process %s: %v", name, err)
+			}
+			result = append(result, value)
 		}
+		return result, nil
 	}
-	return line, lastLine, io.EOF
+	return nil, fmt.Errorf("No \"NSpid\" in status of process %s", name)
 }
 
-func GetProcessMemoryPeak(pid int) (int64, error) {
-	path := fmt.Sprintf("/proc/%d/status", pid)
+// ClockTicks - число тактов в секунду (USER_HZ), в которых ядро сообщает время процессора
+// в "/proc/<pid>/stat".
+const ClockTicks = 100
 
-	f, err := os.Open(path)
+// GetProcessStats возвращает количество времени занимаемое процессом <pid> в CPU и занимаемую им виртуальную память.
+func GetProcessStats(pid int) (uint64, uint64, error) {
+	path := fmt.Sprintf("/proc/%d/stat", pid)
+	fields, err := readStatFields(path)
 	if err != nil {
-		return 0, err
+		return 0, 0, err
 	}
-	defer f.Close()
 
-	line, _, err := readLine(f, 17)
+	// Номера полей - как в proc(5), отсчет от state (3).
+	var values [4]uint64
+	for i, name := range []string{"utime", "stime", "cutime", "cstime"} {
+		if values[i], err = strconv.ParseUint(fields[11+i], 10, 64); err != nil {
+			return 0, 0, fmt.Errorf("Unable to parse \"%s\" from \"%s\" file: %v", name, path, err)
+		}
+	}
+	vsize, err := strconv.ParseUint(fields[20], 10, 64)
 	if err != nil {
-		return 0, err
+		return 0, 0, fmt.Errorf("Unable to parse \"vsize\" from \"%s\" file: %v", path, err)
 	}
+	return values[0] + values[1] + values[2] + values[3], vsize, nil
+}
 
-	fields := strings.Fields(line)
-	value, err := strconv.ParseInt(fields[1], 10, 64)
+// GetProcessMemoryPeak возвращает пиковый размер резидентной памяти (VmHWM) процесса <pid> в килобайтах.
+func GetProcessMemoryPeak(pid int) (int64, error) {
+	status, err := GetProcessStatus(pid)
 	if err != nil {
 		return 0, err
 	}
-
-	return value, nil
+	return status.VmHWM, nil
 }
 
 // GetProcessCommand возвращает комманду запуска указанного процесса.
@@ -222,6 +254,22 @@ func GetProcessCommand(pid int) string {
 	return string(cmdline)
 }
 
+// GetProcessExecutable возвращает путь к исполняемому файлу процесса <pid> (номер процесса - как в /proc).
+func GetProcessExecutable(pid int) (string, error) {
+	return os.Readlink("/proc/" + strconv.Itoa(pid) + "/exe")
+}
+
+// GetProcessCwd возвращает текущий каталог процесса <pid> (номер процесса - как в /proc).
+func GetProcessCwd(pid int) (string, error) {
+	return os.Readlink("/proc/" + strconv.Itoa(pid) + "/cwd")
+}
+
+// GetProcessFdPath возвращает путь к файлу, открытому процессом <pid> (номер процесса - как в /proc)
+// под дескриптором <fd>.
+func GetProcessFdPath(pid, fd int) (string, error) {
+	return os.Readlink("/proc/" + strconv.Itoa(pid) + "/fd/" + strconv.Itoa(fd))
+}
+
 func KillGroup(pid int) (int, error) {
 	pgid, err := syscall.Getpgid(pid)
 
@@ -264,3 +312,13 @@ func GetCPUCount(pid int) (int, error) {
 	}
 	return number, nil
 }
+
+// SetProcessRlimit устанавливает мягкое и жесткое ограничение ресурса <resource> процесса <pid> равным <limit>.
+func SetProcessRlimit(pid, resource int, limit uint64) error {
+	rlimit := unix.Rlimit{Cur: limit, Max: limit}
+	_, _, errno := unix.RawSyscall6(unix.SYS_PRLIMIT64, uintptr(pid), uintptr(resource), uintptr(unsafe.Pointer(&rlimit)), 0, 0, 0)
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
@@ -0,0 +1,260 @@
+package system
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// ProcessUsage содержит показатели процесса из "/proc/<pid>/stat", "status" и "io".
+type ProcessUsage struct {
+	PID  int
+	PPID int
+	// CPUTime - время процессора (utime + stime) в тактах ClockTicks.
+	CPUTime uint64
+	// VmRSS и VmHWM - текущий и пиковый размер резидентной памяти в килобайтах.
+	VmRSS   int64
+	VmHWM   int64
+	Threads int64
+	// ReadBytes и WriteBytes - количество прочитанных и записанных байт (rchar и wchar).
+	ReadBytes  uint64
+	WriteBytes uint64
+}
+
+// GetProcessTreeUsage возвращает показатели процесса <pid> и всех его потомков
+// (номера процессов - как в /proc, см. ProcfsPid). Процессы, завершившиеся во время
+// чтения, пропускаются; если завершился сам процесс <pid>, возвращается ошибка.
+func GetProcessTreeUsage(pid int) ([]ProcessUsage, error) {
+	entries, err := ioutil.ReadDir("/proc")
+	if err != nil {
+		return nil, err
+	}
+
+	stats := make(map[int]*ProcessUsage)
+	children := make(map[int][]int)
+	for _, entry := range entries {
+		p, err := strconv.Atoi(entry.Name())
+		if err != nil {
+			continue
+		}
+
+		usage, err := readProcessStat(p)
+		if err != nil {
+			continue
+		}
+		stats[p] = usage
+		children[usage.PPID] = append(children[usage.PPID], p)
+	}
+
+	if _, ok := stats[pid]; !ok {
+		return nil, fmt.Errorf("Process %d is not found in /proc", pid)
+	}
+
+	var result []ProcessUsage
+	queue := []int{pid}
+	for len(queue) > 0 {
+		p := queue[0]
+		queue = append(queue[1:], children[p]...)
+
+		usage := stats[p]
+		if err := readProcessStatus(usage); err != nil {
+			if p == pid {
+				return nil, err
+			}
+			continue
+		}
+		// "io" может быть недоступен (например, без CONFIG_TASK_IO_ACCOUNTING).
+		readProcessIO(usage)
+		result = append(result, *usage)
+	}
+	return result, nil
+}
+
+// ProcessStatus содержит показатели процесса из "/proc/<pid>/status" и "/proc/<pid>/stat".
+// Поля status ищутся по ключам, а не по номерам строк, которые зависят от версии ядра.
+type ProcessStatus struct {
+	// State - состояние процесса ('R', 'S', 'D', 'Z', 't' и т.д.).
+	State byte
+
+	// Память в килобайтах: пиковый размер адресного пространства, пиковый и текущий размер
+	// резидентной памяти, анонимная резидентная память и память в swap.
+	VmPeak  int64
+	VmHWM   int64
+	VmRSS   int64
+	RssAnon int64
+	VmSwap  int64
+	Threads int64
+	// SigCgt - маска сигналов, для которых процесс установил обработчик (бит <номер - 1>).
+	SigCgt uint64
+
+	// UTime и STime - время процессора процесса, CUTime и CSTime - дождавшихся его потомков
+	// (в тактах ClockTicks).
+	UTime  uint64
+	STime  uint64
+	CUTime uint64
+	CSTime uint64
+}
+
+// GetProcessStatus возвращает показатели процесса <pid> (номер процесса - как в /proc).
+func GetProcessStatus(pid int) (*ProcessStatus, error) {
+	statPath := fmt.Sprintf("/proc/%d/stat", pid)
+	stat, err := ioutil.ReadFile(statPath)
+	if err != nil {
+		return nil, err
+	}
+	statusPath := fmt.Sprintf("/proc/%d/status", pid)
+	status, err := ioutil.ReadFile(statusPath)
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := parseProcessStatus(stat, status)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to parse \"%s\" file: %v", statPath, err)
+	}
+	return result, nil
+}
+
+// parseProcessStatus разбирает содержимое файлов "stat" и "status" процесса.
+// Отсутствующие в "status" ключи (например, RssAnon и VmSwap в старых ядрах) считаются нулевыми.
+func parseProcessStatus(stat, status []byte) (*ProcessStatus, error) {
+	fields, err := parseStatFields(stat)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &ProcessStatus{State: fields[0][0]}
+	times := []*uint64{&result.UTime, &result.STime, &result.CUTime, &result.CSTime}
+	for i, name := range []string{"utime", "stime", "cutime", "cstime"} {
+		if *times[i], err = strconv.ParseUint(fields[11+i], 10, 64); err != nil {
+			return nil, fmt.Errorf("Invalid \"%s\" field: %v", name, err)
+		}
+	}
+
+	values := parseProcKeys(status)
+	result.VmPeak = parseProcInt(values["VmPeak"])
+	result.VmHWM = parseProcInt(values["VmHWM"])
+	result.VmRSS = parseProcInt(values["VmRSS"])
+	result.RssAnon = parseProcInt(values["RssAnon"])
+	result.VmSwap = parseProcInt(values["VmSwap"])
+	result.Threads = parseProcInt(values["Threads"])
+	result.SigCgt, _ = strconv.ParseUint(values["SigCgt"], 16, 64)
+	return result, nil
+}
+
+// Handles возвращает true, если процесс установил обработчик сигнала <sig>.
+func (s *ProcessStatus) Handles(sig syscall.Signal) bool {
+	return sig > 0 && sig <= 64 && s.SigCgt&(1<<uint(sig-1)) != 0
+}
+
+// readStatFields возвращает поля файла "stat" <path> после имени процесса (см. parseStatFields).
+func readStatFields(path string) ([]string, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	fields, err := parseStatFields(data)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to parse \"%s\" file: %v", path, err)
+	}
+	return fields, nil
+}
+
+// parseStatFields возвращает поля содержимого файла "stat" после имени процесса, начиная
+// с state (3). Имя может содержать пробелы и скобки, поэтому поля отсчитываются от последней ')'.
+func parseStatFields(data []byte) ([]string, error) {
+	end := strings.LastIndexByte(string(data), ')')
+	if end < 0 {
+		return nil, errors.New("No process name")
+	}
+	// Поля после имени: state (3), ppid (4), ..., utime (14), stime (15), ..., vsize (23).
+	fields := strings.Fields(string(data[end+1:]))
+	if len(fields) < 21 {
+		return nil, errors.New("Too few fields")
+	}
+	return fields, nil
+}
+
+// readProcessStat читает родителя и время процессора из "/proc/<pid>/stat".
+func readProcessStat(pid int) (*ProcessUsage, error) {
+	path := fmt.Sprintf("/proc/%d/stat", pid)
+	fields, err := readStatFields(path)
+	if err != nil {
+		return nil, err
+	}
+
+	usage := &ProcessUsage{PID: pid}
+	if usage.PPID, err = strconv.Atoi(fields[1]); err != nil {
+		return nil, fmt.Errorf("Unable to parse \"ppid\" from \"%s\" file: %v", path, err)
+	}
+	utime, err := strconv.ParseUint(fields[11], 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to parse \"utime\" from \"%s\" file: %v", path, err)
+	}
+	stime, err := strconv.ParseUint(fields[12], 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to parse \"stime\" from \"%s\" file: %v", path, err)
+	}
+	usage.CPUTime = utime + stime
+	return usage, nil
+}
+
+func readProcessStatus(usage *ProcessUsage) error {
+	values, err := readProcKeys(fmt.Sprintf("/proc/%d/status", usage.PID))
+	if err != nil {
+		return err
+	}
+
+	usage.VmRSS = parseProcInt(values["VmRSS"])
+	usage.VmHWM = parseProcInt(values["VmHWM"])
+	usage.Threads = parseProcInt(values["Threads"])
+	return nil
+}
+
+func readProcessIO(usage *ProcessUsage) error {
+	values, err := readProcKeys(fmt.Sprintf("/proc/%d/io", usage.PID))
+	if err != nil {
+		return err
+	}
+
+	usage.ReadBytes = uint64(parseProcInt(values["rchar"]))
+	usage.WriteBytes = uint64(parseProcInt(values["wchar"]))
+	return nil
+}
+
+// readProcKeys читает файл вида "<ключ>: <значение>" (status, io) в словарь.
+func readProcKeys(path string) (map[string]string, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return parseProcKeys(data), nil
+}
+
+// parseProcKeys разбирает строки вида "<ключ>: <значение>", строки без ':' пропускаются.
+func parseProcKeys(data []byte) map[string]string {
+	values := make(map[string]string)
+	for _, line := range strings.Split(string(data), "\n") {
+		index := strings.IndexByte(line, ':')
+		if index < 0 {
+			continue
+		}
+		values[line[:index]] = strings.TrimSpace(line[index+1:])
+	}
+	return values
+}
+
+// parseProcInt разбирает числовое значение, отбрасывая единицы измерения ("1024 kB").
+// Отсутствующие значения (например, VmRSS у зомби) считаются нулевыми.
+func parseProcInt(value string) int64 {
+	fields := strings.Fields(value)
+	if len(fields) == 0 {
+		return 0
+	}
+	result, _ := strconv.ParseInt(fields[0], 10, 64)
+	return result
+}
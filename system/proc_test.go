@@ -0,0 +1,115 @@
+package system
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+func readFixture(t *testing.T, name string) []byte {
+	data, err := ioutil.ReadFile(filepath.Join("testdata", "proc", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestParseProcessStatus(t *testing.T) {
+	tests := []struct {
+		name   string
+		stat   string
+		status string
+		want   ProcessStatus
+	}{
+		{
+			name:   "full",
+			stat:   "stat",
+			status: "status",
+			want: ProcessStatus{
+				State: 'R', VmPeak: 2640, VmHWM: 1308, VmRSS: 1296, RssAnon: 104, VmSwap: 16, Threads: 2,
+				SigCgt: 0x4402, UTime: 120, STime: 30,
+			},
+		},
+		{
+			name:   "missing RssAnon and VmSwap",
+			stat:   "stat",
+			status: "status_old",
+			want: ProcessStatus{
+				State: 'R', VmPeak: 2640, VmHWM: 1308, VmRSS: 1296, Threads: 1, UTime: 120, STime: 30,
+			},
+		},
+		{
+			name:   "zombie without memory",
+			stat:   "stat",
+			status: "status_zombie",
+			want:   ProcessStatus{State: 'R', Threads: 1, UTime: 120, STime: 30},
+		},
+		{
+			name:   "parentheses in name",
+			stat:   "stat_parens",
+			status: "status_old",
+			want: ProcessStatus{
+				State: 'S', VmPeak: 2640, VmHWM: 1308, VmRSS: 1296, Threads: 1, UTime: 17, STime: 5, CUTime: 3, CSTime: 2,
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			status, err := parseProcessStatus(readFixture(t, test.stat), readFixture(t, test.status))
+			if err != nil {
+				t.Fatalf("parseProcessStatus returned error: %v", err)
+			}
+			if *status != test.want {
+				t.Errorf("status = %+v, want %+v", *status, test.want)
+			}
+		})
+	}
+}
+
+func TestParseStatFields(t *testing.T) {
+	fields, err := parseStatFields(readFixture(t, "stat_parens"))
+	if err != nil {
+		t.Fatalf("parseStatFields returned error: %v", err)
+	}
+	if fields[0] != "S" || fields[1] != "4200" {
+		t.Errorf("state and ppid = %q, %q, want \"S\", \"4200\"", fields[0], fields[1])
+	}
+
+	for _, data := range []string{"", "4242 evil S 1", "4242 (evil) S 1 2 3\n"} {
+		if _, err := parseStatFields([]byte(data)); err == nil {
+			t.Errorf("parseStatFields accepted %q", data)
+		}
+	}
+}
+
+func TestProcessStatusHandles(t *testing.T) {
+	status := ProcessStatus{SigCgt: 0x4402}
+	for sig, want := range map[syscall.Signal]bool{
+		syscall.SIGINT:  true,
+		syscall.SIGSEGV: true,
+		syscall.SIGTERM: true,
+		syscall.SIGKILL: false,
+		syscall.SIGABRT: false,
+		0:               false,
+		65:              false,
+	} {
+		if got := status.Handles(sig); got != want {
+			t.Errorf("Handles(%d) = %t, want %t", sig, got, want)
+		}
+	}
+}
+
+func TestParseProcInt(t *testing.T) {
+	for value, want := range map[string]int64{
+		"1308 kB": 1308,
+		"   42":   42,
+		"":        0,
+		"abc kB":  0,
+	} {
+		if got := parseProcInt(value); got != want {
+			t.Errorf("parseProcInt(%q) = %d, want %d", value, got, want)
+		}
+	}
+}
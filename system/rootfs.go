@@ -1,9 +1,15 @@
 package system
 
 import (
+	"io/ioutil"
 	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
 	"syscall"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
 )
 
 // PivotRoot устанавливает pivot_root для текущего процесса
@@ -61,3 +67,136 @@ func MountProc(newroot string) error {
 
 	return nil
 }
+
+// Номер системного вызова mount_setattr (одинаков для всех архитектур) и его флаги.
+const (
+	sysMountSetattr = 442
+	mountAttrRdonly = 0x1    // MOUNT_ATTR_RDONLY
+	atRecursive     = 0x8000 // AT_RECURSIVE
+)
+
+type mountAttr struct {
+	attrSet     uint64
+	attrClr     uint64
+	propagation uint64
+	usernsFd    uint64
+}
+
+// Флаги точки монтирования, которые нельзя снять при повторном монтировании в user namespace.
+const lockedMountFlags = syscall.MS_NOSUID | syscall.MS_NODEV | syscall.MS_NOEXEC |
+	syscall.MS_NOATIME | syscall.MS_NODIRATIME | syscall.MS_RELATIME
+
+// BindMount монтирует <source> в <target> вместе с вложенными точками монтирования,
+// для <readOnly> - рекурсивно только для чтения. <target> должен существовать.
+func BindMount(source, target string, readOnly bool) error {
+	if err := syscall.Mount(source, target, "", syscall.MS_BIND|syscall.MS_REC, ""); err != nil {
+		return err
+	}
+	if readOnly {
+		return remountReadOnly(target)
+	}
+	return nil
+}
+
+// MountTmpfs монтирует tmpfs размера <size> (в формате параметра "size" tmpfs, например, "64m").
+func MountTmpfs(target, size string) error {
+	return syscall.Mount("tmpfs", target, "tmpfs", syscall.MS_NOSUID|syscall.MS_NODEV, "mode=1777,size="+size)
+}
+
+// MountDev монтирует в <newroot>/dev tmpfs только с устройствами <devices>, привязанными
+// к устройствам текущей ФС (создавать устройства в user namespace нельзя).
+func MountDev(newroot string, devices []string) error {
+	dev := filepath.Join(newroot, "/dev")
+	if err := os.MkdirAll(dev, 0755); err != nil {
+		return err
+	}
+	if err := syscall.Mount("tmpfs", dev, "tmpfs", syscall.MS_NOSUID|syscall.MS_NOEXEC, "mode=755,size=64k"); err != nil {
+		return err
+	}
+
+	for _, name := range devices {
+		target := filepath.Join(dev, name)
+		f, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY, 0666)
+		if err != nil {
+			return err
+		}
+		f.Close()
+		if err := syscall.Mount(filepath.Join("/dev", name), target, "", syscall.MS_BIND, ""); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// remountReadOnly делает точку монтирования <target> и вложенные в нее точки доступными
+// только для чтения. Без mount_setattr (Linux 5.12) точки перемонтируются по одной.
+func remountReadOnly(target string) error {
+	path, err := syscall.BytePtrFromString(target)
+	if err != nil {
+		return err
+	}
+	attr := mountAttr{attrSet: mountAttrRdonly}
+	dirfd := unix.AT_FDCWD
+	_, _, errno := syscall.Syscall6(sysMountSetattr, uintptr(dirfd), uintptr(unsafe.Pointer(path)),
+		atRecursive, uintptr(unsafe.Pointer(&attr)), unsafe.Sizeof(attr), 0)
+	if errno == 0 {
+		return nil
+	}
+	if errno != syscall.ENOSYS {
+		return errno
+	}
+
+	mounts, err := getMountPoints()
+	if err != nil {
+		return err
+	}
+	for _, mount := range mounts {
+		if mount != target && !strings.HasPrefix(mount, target+"/") {
+			continue
+		}
+		var stat syscall.Statfs_t
+		if err := syscall.Statfs(mount, &stat); err != nil {
+			return err
+		}
+		flags := uintptr(stat.Flags) & lockedMountFlags
+		if err := syscall.Mount("", mount, "", syscall.MS_REMOUNT|syscall.MS_BIND|syscall.MS_RDONLY|flags, ""); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// getMountPoints возвращает точки монтирования текущего процесса из /proc/self/mountinfo
+// в порядке монтирования.
+func getMountPoints() ([]string, error) {
+	data, err := ioutil.ReadFile("/proc/self/mountinfo")
+	if err != nil {
+		return nil, err
+	}
+
+	var mounts []string
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 5 {
+			continue
+		}
+		mounts = append(mounts, unescapeMountPath(fields[4]))
+	}
+	return mounts, nil
+}
+
+// unescapeMountPath заменяет восьмеричные последовательности mountinfo ("\040") символами.
+func unescapeMountPath(path string) string {
+	var b strings.Builder
+	for i := 0; i < len(path); i++ {
+		if path[i] == '\\' && i+3 < len(path) {
+			if code, err := strconv.ParseUint(path[i+1:i+4], 8, 8); err == nil {
+				b.WriteByte(byte(code))
+				i += 3
+				continue
+			}
+		}
+		b.WriteByte(path[i])
+	}
+	return b.String()
+}
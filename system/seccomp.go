@@ -0,0 +1,205 @@
+package system
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"syscall"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// Значения, возвращаемые seccomp фильтром.
+const (
+	SeccompKill  uint32 = 0x80000000 // SECCOMP_RET_KILL_PROCESS
+	SeccompErrno uint32 = 0x00050000 // SECCOMP_RET_ERRNO
+	SeccompTrace uint32 = 0x7ff00000 // SECCOMP_RET_TRACE
+	SeccompAllow uint32 = 0x7fff0000 // SECCOMP_RET_ALLOW
+)
+
+// Операции сравнения аргументов системного вызова.
+const (
+	SeccompArgEqual       = "eq"
+	SeccompArgNotEqual    = "ne"
+	SeccompArgMaskedEqual = "masked_eq"
+)
+
+const (
+	seccompDataNr   = 0
+	seccompDataArch = 4
+	seccompDataArgs = 16
+
+	// Системные вызовы x32 ABI помечаются этим битом в номере.
+	x32SyscallBit = 0x40000000
+
+	bpfMaxInstructions = 4096
+)
+
+// SeccompArg - условие на аргумент системного вызова с индексом <Index>.
+// Сравниваются только младшие 32 бита аргумента.
+type SeccompArg struct {
+	Index int
+	Op    string
+	Mask  uint32
+	Value uint32
+}
+
+// SeccompRule определяет действие фильтра для одного системного вызова.
+// Если указаны условия <Args>, то <Action> применяется только при выполнении всех условий,
+// в противном случае применяется <Otherwise>.
+type SeccompRule struct {
+	Syscall   int
+	Action    uint32
+	Args      []SeccompArg
+	Otherwise uint32
+}
+
+// BuildSeccompFilter собирает BPF программу, которая для каждого системного вызова из <rules>
+// возвращает указанное в правиле действие, а для всех остальных - <defaultAction>.
+// Вызовы чужой архитектуры и x32 ABI завершают процесс.
+func BuildSeccompFilter(rules []SeccompRule, defaultAction uint32) ([]unix.SockFilter, error) {
+	if auditArch == 0 {
+		return nil, errors.New("Seccomp filter is not supported on this architecture")
+	}
+
+	filter := []unix.SockFilter{
+		bpfStmt(unix.BPF_LD|unix.BPF_W|unix.BPF_ABS, seccompDataArch),
+		bpfJump(unix.BPF_JMP|unix.BPF_JEQ|unix.BPF_K, auditArch, 1, 0),
+		bpfStmt(unix.BPF_RET|unix.BPF_K, SeccompKill),
+		bpfStmt(unix.BPF_LD|unix.BPF_W|unix.BPF_ABS, seccompDataNr),
+		bpfJump(unix.BPF_JMP|unix.BPF_JGE|unix.BPF_K, x32SyscallBit, 0, 1),
+		bpfStmt(unix.BPF_RET|unix.BPF_K, SeccompKill),
+	}
+
+	for _, rule := range rules {
+		block, err := buildArgsBlock(rule)
+		if err != nil {
+			return nil, err
+		}
+		if len(block) > 255 {
+			return nil, fmt.Errorf("Too many argument conditions for syscall %d", rule.Syscall)
+		}
+		filter = append(filter, bpfJump(unix.BPF_JMP|unix.BPF_JEQ|unix.BPF_K, uint32(rule.Syscall), 0, uint8(len(block))))
+		filter = append(filter, block...)
+	}
+	filter = append(filter, bpfStmt(unix.BPF_RET|unix.BPF_K, defaultAction))
+
+	if len(filter) > bpfMaxInstructions {
+		return nil, fmt.Errorf("Seccomp filter is too large (%d instructions)", len(filter))
+	}
+	return filter, nil
+}
+
+// buildArgsBlock собирает часть программы, выполняемую при совпадении номера вызова.
+// Все ветви блока завершаются инструкцией RET, поэтому аккумулятор после него перезагружать не нужно.
+func buildArgsBlock(rule SeccompRule) ([]unix.SockFilter, error) {
+	if len(rule.Args) == 0 {
+		return []unix.SockFilter{bpfStmt(unix.BPF_RET|unix.BPF_K, rule.Action)}, nil
+	}
+
+	var checks [][]unix.SockFilter
+	for _, arg := range rule.Args {
+		if arg.Index < 0 || arg.Index > 5 {
+			return nil, fmt.Errorf("Invalid argument index %d for syscall %d", arg.Index, rule.Syscall)
+		}
+
+		check := []unix.SockFilter{bpfStmt(unix.BPF_LD|unix.BPF_W|unix.BPF_ABS, uint32(seccompDataArgs+8*arg.Index))}
+		switch arg.Op {
+		case SeccompArgEqual, SeccompArgNotEqual:
+			check = append(check, bpfJump(unix.BPF_JMP|unix.BPF_JEQ|unix.BPF_K, arg.Value, 0, 0))
+		case SeccompArgMaskedEqual:
+			check = append(check,
+				bpfStmt(unix.BPF_ALU|unix.BPF_AND|unix.BPF_K, arg.Mask),
+				bpfJump(unix.BPF_JMP|unix.BPF_JEQ|unix.BPF_K, arg.Value, 0, 0),
+			)
+		default:
+			return nil, fmt.Errorf("Unknown argument operation \"%s\"", arg.Op)
+		}
+		checks = append(checks, check)
+	}
+
+	// Размер блока: проверки, RET <Action> и RET <Otherwise>.
+	size := 2
+	for _, check := range checks {
+		size += len(check)
+	}
+
+	var block []unix.SockFilter
+	for i, check := range checks {
+		block = append(block, check...)
+
+		// Расстояние от текущей инструкции сравнения до RET <Otherwise>.
+		fail := uint8(size - len(block) - 1)
+		jump := &block[len(block)-1]
+		if rule.Args[i].Op == SeccompArgNotEqual {
+			jump.Jt = fail
+		} else {
+			jump.Jf = fail
+		}
+	}
+
+	block = append(block,
+		bpfStmt(unix.BPF_RET|unix.BPF_K, rule.Action),
+		bpfStmt(unix.BPF_RET|unix.BPF_K, rule.Otherwise),
+	)
+	return block, nil
+}
+
+// InstallSeccompFilter устанавливает фильтр <filter> для текущего потока.
+// Перед установкой выставляется флаг "no_new_privs", без которого непривилегированный процесс
+// не может установить фильтр.
+func InstallSeccompFilter(filter []unix.SockFilter) error {
+	if len(filter) == 0 {
+		return errors.New("Seccomp filter is empty")
+	}
+
+	if err := unix.Prctl(unix.PR_SET_NO_NEW_PRIVS, 1, 0, 0, 0); err != nil {
+		return fmt.Errorf("Unable to set \"no_new_privs\": %v", err)
+	}
+
+	prog := unix.SockFprog{
+		Len:    uint16(len(filter)),
+		Filter: &filter[0],
+	}
+	if err := unix.Prctl(unix.PR_SET_SECCOMP, unix.SECCOMP_MODE_FILTER, uintptr(unsafe.Pointer(&prog)), 0, 0); err != nil {
+		return fmt.Errorf("Unable to install seccomp filter: %v", err)
+	}
+	return nil
+}
+
+// WriteSeccompFilter записывает BPF программу <filter> в <w>.
+func WriteSeccompFilter(w io.Writer, filter []unix.SockFilter) error {
+	return binary.Write(w, binary.LittleEndian, filter)
+}
+
+// ReadSeccompFilter читает из <r> BPF программу, записанную функцией WriteSeccompFilter.
+func ReadSeccompFilter(r io.Reader) ([]unix.SockFilter, error) {
+	var filter []unix.SockFilter
+	for {
+		var instruction unix.SockFilter
+		err := binary.Read(r, binary.LittleEndian, &instruction)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		filter = append(filter, instruction)
+	}
+	return filter, nil
+}
+
+// SeccompErrnoAction возвращает действие фильтра, при котором вызов завершается с ошибкой <errno>.
+func SeccompErrnoAction(errno syscall.Errno) uint32 {
+	return SeccompErrno | (uint32(errno) & 0xffff)
+}
+
+func bpfStmt(code uint16, k uint32) unix.SockFilter {
+	return unix.SockFilter{Code: code, K: k}
+}
+
+func bpfJump(code uint16, k uint32, jt, jf uint8) unix.SockFilter {
+	return unix.SockFilter{Code: code, Jt: jt, Jf: jf, K: k}
+}
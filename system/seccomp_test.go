@@ -0,0 +1,209 @@
+package system
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"syscall"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+// seccompData соответствует struct seccomp_data.
+type seccompData struct {
+	nr   int32
+	arch uint32
+	args [6]uint64
+}
+
+func (d seccompData) bytes() []byte {
+	buf := make([]byte, seccompDataArgs+8*len(d.args))
+	binary.LittleEndian.PutUint32(buf[seccompDataNr:], uint32(d.nr))
+	binary.LittleEndian.PutUint32(buf[seccompDataArch:], d.arch)
+	for i, arg := range d.args {
+		binary.LittleEndian.PutUint64(buf[seccompDataArgs+8*i:], arg)
+	}
+	return buf
+}
+
+// runFilter выполняет BPF программу <filter> над <data> так же, как ядро, и возвращает
+// результат. Поддерживаются только инструкции, которые генерирует BuildSeccompFilter.
+func runFilter(filter []unix.SockFilter, data seccompData) (uint32, error) {
+	input := data.bytes()
+	var acc uint32
+	for pc := 0; pc < len(filter); pc++ {
+		ins := filter[pc]
+		switch ins.Code {
+		case unix.BPF_LD | unix.BPF_W | unix.BPF_ABS:
+			if int(ins.K)+4 > len(input) {
+				return 0, fmt.Errorf("Load out of bounds at %d", pc)
+			}
+			acc = binary.LittleEndian.Uint32(input[ins.K:])
+		case unix.BPF_ALU | unix.BPF_AND | unix.BPF_K:
+			acc &= ins.K
+		case unix.BPF_JMP | unix.BPF_JEQ | unix.BPF_K, unix.BPF_JMP | unix.BPF_JGE | unix.BPF_K:
+			matched := acc == ins.K
+			if ins.Code&0xf0 == unix.BPF_JGE {
+				matched = acc >= ins.K
+			}
+			if matched {
+				pc += int(ins.Jt)
+			} else {
+				pc += int(ins.Jf)
+			}
+		case unix.BPF_RET | unix.BPF_K:
+			return ins.K, nil
+		default:
+			return 0, fmt.Errorf("Unsupported instruction 0x%x at %d", ins.Code, pc)
+		}
+	}
+	return 0, fmt.Errorf("Program ends without return")
+}
+
+func TestBuildSeccompFilter(t *testing.T) {
+	if auditArch == 0 {
+		t.Skip("seccomp filter is not supported on this architecture")
+	}
+
+	eperm := SeccompErrnoAction(syscall.EPERM)
+	rules := []SeccompRule{
+		{Syscall: 0, Action: SeccompAllow},
+		{Syscall: 62, Action: SeccompKill},
+		{
+			Syscall:   1,
+			Action:    SeccompAllow,
+			Args:      []SeccompArg{{Index: 0, Op: SeccompArgEqual, Value: 1}},
+			Otherwise: eperm,
+		},
+		{
+			Syscall:   257,
+			Action:    SeccompAllow,
+			Args:      []SeccompArg{{Index: 2, Op: SeccompArgMaskedEqual, Mask: syscall.O_ACCMODE, Value: syscall.O_RDONLY}},
+			Otherwise: SeccompTrace,
+		},
+		{
+			Syscall:   9,
+			Action:    SeccompAllow,
+			Args:      []SeccompArg{{Index: 2, Op: SeccompArgNotEqual, Value: 7}, {Index: 5, Op: SeccompArgEqual, Value: 0}},
+			Otherwise: SeccompKill,
+		},
+	}
+	filter, err := BuildSeccompFilter(rules, SeccompTrace)
+	if err != nil {
+		t.Fatalf("BuildSeccompFilter returned error: %v", err)
+	}
+
+	tests := []struct {
+		name string
+		data seccompData
+		want uint32
+	}{
+		{"allowed", seccompData{nr: 0}, SeccompAllow},
+		{"denied", seccompData{nr: 62}, SeccompKill},
+		{"default", seccompData{nr: 2}, SeccompTrace},
+		{"foreign architecture", seccompData{nr: 0, arch: 0x40000003}, SeccompKill},
+		{"x32 syscall", seccompData{nr: x32SyscallBit}, SeccompKill},
+		{"equal matched", seccompData{nr: 1, args: [6]uint64{1}}, SeccompAllow},
+		{"equal not matched", seccompData{nr: 1, args: [6]uint64{2}}, eperm},
+		{"equal compares low bits", seccompData{nr: 1, args: [6]uint64{1<<32 | 1}}, SeccompAllow},
+		{"masked matched", seccompData{nr: 257, args: [6]uint64{0, 0, syscall.O_RDONLY | syscall.O_CLOEXEC}}, SeccompAllow},
+		{"masked not matched", seccompData{nr: 257, args: [6]uint64{0, 0, syscall.O_RDWR | syscall.O_CLOEXEC}}, SeccompTrace},
+		{"all conditions matched", seccompData{nr: 9, args: [6]uint64{0, 0, 3, 0, 0, 0}}, SeccompAllow},
+		{"not equal failed", seccompData{nr: 9, args: [6]uint64{0, 0, 7, 0, 0, 0}}, SeccompKill},
+		{"second condition failed", seccompData{nr: 9, args: [6]uint64{0, 0, 3, 0, 0, 1}}, SeccompKill},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if test.data.arch == 0 {
+				test.data.arch = auditArch
+			}
+			got, err := runFilter(filter, test.data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != test.want {
+				t.Errorf("filter returned 0x%x, want 0x%x", got, test.want)
+			}
+		})
+	}
+}
+
+func TestBuildArgsBlock(t *testing.T) {
+	block, err := buildArgsBlock(SeccompRule{
+		Syscall:   1,
+		Action:    SeccompAllow,
+		Args:      []SeccompArg{{Index: 0, Op: SeccompArgNotEqual, Value: 2}, {Index: 1, Op: SeccompArgMaskedEqual, Mask: 0xf0, Value: 0x10}},
+		Otherwise: SeccompKill,
+	})
+	if err != nil {
+		t.Fatalf("buildArgsBlock returned error: %v", err)
+	}
+
+	want := []unix.SockFilter{
+		bpfStmt(unix.BPF_LD|unix.BPF_W|unix.BPF_ABS, seccompDataArgs),
+		bpfJump(unix.BPF_JMP|unix.BPF_JEQ|unix.BPF_K, 2, 4, 0),
+		bpfStmt(unix.BPF_LD|unix.BPF_W|unix.BPF_ABS, seccompDataArgs+8),
+		bpfStmt(unix.BPF_ALU|unix.BPF_AND|unix.BPF_K, 0xf0),
+		bpfJump(unix.BPF_JMP|unix.BPF_JEQ|unix.BPF_K, 0x10, 0, 1),
+		bpfStmt(unix.BPF_RET|unix.BPF_K, SeccompAllow),
+		bpfStmt(unix.BPF_RET|unix.BPF_K, SeccompKill),
+	}
+	if len(block) != len(want) {
+		t.Fatalf("block has %d instructions, want %d: %+v", len(block), len(want), block)
+	}
+	for i := range want {
+		if block[i] != want[i] {
+			t.Errorf("instruction %d = %+v, want %+v", i, block[i], want[i])
+		}
+	}
+}
+
+func TestBuildSeccompFilterErrors(t *testing.T) {
+	if auditArch == 0 {
+		t.Skip("seccomp filter is not supported on this architecture")
+	}
+
+	tests := []struct {
+		name string
+		arg  SeccompArg
+	}{
+		{"negative index", SeccompArg{Index: -1, Op: SeccompArgEqual}},
+		{"index out of range", SeccompArg{Index: 6, Op: SeccompArgEqual}},
+		{"unknown operation", SeccompArg{Index: 0, Op: "gt"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rules := []SeccompRule{{Syscall: 1, Action: SeccompAllow, Args: []SeccompArg{test.arg}}}
+			if _, err := BuildSeccompFilter(rules, SeccompTrace); err == nil {
+				t.Error("BuildSeccompFilter accepted invalid rule")
+			}
+		})
+	}
+}
+
+func TestSeccompFilterRoundTrip(t *testing.T) {
+	filter := []unix.SockFilter{
+		bpfStmt(unix.BPF_LD|unix.BPF_W|unix.BPF_ABS, seccompDataNr),
+		bpfJump(unix.BPF_JMP|unix.BPF_JEQ|unix.BPF_K, 1, 0, 1),
+		bpfStmt(unix.BPF_RET|unix.BPF_K, SeccompAllow),
+		bpfStmt(unix.BPF_RET|unix.BPF_K, SeccompKill),
+	}
+
+	var buf bytes.Buffer
+	if err := WriteSeccompFilter(&buf, filter); err != nil {
+		t.Fatalf("WriteSeccompFilter returned error: %v", err)
+	}
+	read, err := ReadSeccompFilter(&buf)
+	if err != nil {
+		t.Fatalf("ReadSeccompFilter returned error: %v", err)
+	}
+	if len(read) != len(filter) {
+		t.Fatalf("read %d instructions, want %d", len(read), len(filter))
+	}
+	for i := range filter {
+		if read[i] != filter[i] {
+			t.Errorf("instruction %d = %+v, want %+v", i, read[i], filter[i])
+		}
+	}
+}
@@ -0,0 +1,182 @@
+package system
+
+import (
+	"encoding/binary"
+	"fmt"
+	"syscall"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// Значения si_code, общие для всех сигналов.
+const (
+	SignalUser   = 0    // SI_USER
+	SignalKernel = 0x80 // SI_KERNEL
+)
+
+// siginfoSize - размер siginfo_t.
+const siginfoSize = 128
+
+// Siginfo содержит поля siginfo_t остановленного процесса.
+type Siginfo struct {
+	Signo int32
+	Errno int32
+	Code  int32
+	// Addr - адрес, вызвавший ошибку (SIGSEGV, SIGBUS, SIGFPE, SIGILL).
+	Addr uint64
+	// Pid и Uid - отправитель сигнала (kill, tkill).
+	Pid int32
+	Uid uint32
+}
+
+// GetSiginfo возвращает siginfo_t сигнала, на котором остановлен процесс <pid> (PTRACE_GETSIGINFO).
+func GetSiginfo(pid int) (*Siginfo, error) {
+	var buf [siginfoSize]byte
+	_, _, errno := syscall.Syscall6(syscall.SYS_PTRACE, unix.PTRACE_GETSIGINFO, uintptr(pid), 0,
+		uintptr(unsafe.Pointer(&buf[0])), 0, 0)
+	if errno != 0 {
+		return nil, errno
+	}
+
+	// Объединение полей начинается после si_signo, si_errno, si_code и выравнивания до указателя.
+	union := 3 * 4
+	if unsafe.Sizeof(uintptr(0)) == 8 {
+		union = 4 * 4
+	}
+
+	order := binary.LittleEndian
+	info := &Siginfo{
+		Signo: int32(order.Uint32(buf[0:])),
+		Errno: int32(order.Uint32(buf[4:])),
+		Code:  int32(order.Uint32(buf[8:])),
+		Pid:   int32(order.Uint32(buf[union:])),
+		Uid:   order.Uint32(buf[union+4:]),
+	}
+	if unsafe.Sizeof(uintptr(0)) == 8 {
+		info.Addr = order.Uint64(buf[union:])
+	} else {
+		info.Addr = uint64(order.Uint32(buf[union:]))
+	}
+	return info, nil
+}
+
+// FaultSignal возвращает true для сигналов, которые ядро отправляет при ошибке выполнения
+// инструкции и в siginfo которых указан адрес.
+func FaultSignal(sig syscall.Signal) bool {
+	switch sig {
+	case syscall.SIGSEGV, syscall.SIGBUS, syscall.SIGFPE, syscall.SIGILL:
+		return true
+	}
+	return false
+}
+
+var signalNames = map[syscall.Signal]string{
+	syscall.SIGHUP:    "SIGHUP",
+	syscall.SIGINT:    "SIGINT",
+	syscall.SIGQUIT:   "SIGQUIT",
+	syscall.SIGILL:    "SIGILL",
+	syscall.SIGTRAP:   "SIGTRAP",
+	syscall.SIGABRT:   "SIGABRT",
+	syscall.SIGBUS:    "SIGBUS",
+	syscall.SIGFPE:    "SIGFPE",
+	syscall.SIGKILL:   "SIGKILL",
+	syscall.SIGUSR1:   "SIGUSR1",
+	syscall.SIGSEGV:   "SIGSEGV",
+	syscall.SIGUSR2:   "SIGUSR2",
+	syscall.SIGPIPE:   "SIGPIPE",
+	syscall.SIGALRM:   "SIGALRM",
+	syscall.SIGTERM:   "SIGTERM",
+	syscall.SIGSTKFLT: "SIGSTKFLT",
+	syscall.SIGCHLD:   "SIGCHLD",
+	syscall.SIGCONT:   "SIGCONT",
+	syscall.SIGSTOP:   "SIGSTOP",
+	syscall.SIGTSTP:   "SIGTSTP",
+	syscall.SIGTTIN:   "SIGTTIN",
+	syscall.SIGTTOU:   "SIGTTOU",
+	syscall.SIGURG:    "SIGURG",
+	syscall.SIGXCPU:   "SIGXCPU",
+	syscall.SIGXFSZ:   "SIGXFSZ",
+	syscall.SIGVTALRM: "SIGVTALRM",
+	syscall.SIGPROF:   "SIGPROF",
+	syscall.SIGWINCH:  "SIGWINCH",
+	syscall.SIGIO:     "SIGIO",
+	syscall.SIGPWR:    "SIGPWR",
+	syscall.SIGSYS:    "SIGSYS",
+}
+
+// SignalName возвращает имя сигнала ("SIGSEGV").
+func SignalName(sig syscall.Signal) string {
+	if name, ok := signalNames[sig]; ok {
+		return name
+	}
+	return fmt.Sprintf("SIG%d", int(sig))
+}
+
+// signalCode - имя и описание значения si_code.
+type signalCode struct {
+	name        string
+	description string
+}
+
+// signalCodes содержит значения si_code для сигналов ошибок выполнения (см. sigaction(2)).
+var signalCodes = map[syscall.Signal]map[int32]signalCode{
+	syscall.SIGSEGV: {
+		1: {"SEGV_MAPERR", "address not mapped to object"},
+		2: {"SEGV_ACCERR", "invalid permissions for mapped object"},
+		3: {"SEGV_BNDERR", "failed address bound checks"},
+		4: {"SEGV_PKUERR", "access was denied by memory protection keys"},
+	},
+	syscall.SIGBUS: {
+		1: {"BUS_ADRALN", "invalid address alignment"},
+		2: {"BUS_ADRERR", "nonexistent physical address"},
+		3: {"BUS_OBJERR", "object-specific hardware error"},
+		4: {"BUS_MCEERR_AR", "hardware memory error consumed on a machine check"},
+		5: {"BUS_MCEERR_AO", "hardware memory error detected in process but not consumed"},
+	},
+	syscall.SIGFPE: {
+		1: {"FPE_INTDIV", "integer divide by zero"},
+		2: {"FPE_INTOVF", "integer overflow"},
+		3: {"FPE_FLTDIV", "floating-point divide by zero"},
+		4: {"FPE_FLTOVF", "floating-point overflow"},
+		5: {"FPE_FLTUND", "floating-point underflow"},
+		6: {"FPE_FLTRES", "floating-point inexact result"},
+		7: {"FPE_FLTINV", "floating-point invalid operation"},
+		8: {"FPE_FLTSUB", "subscript out of range"},
+	},
+	syscall.SIGILL: {
+		1: {"ILL_ILLOPC", "illegal opcode"},
+		2: {"ILL_ILLOPN", "illegal operand"},
+		3: {"ILL_ILLADR", "illegal addressing mode"},
+		4: {"ILL_ILLTRP", "illegal trap"},
+		5: {"ILL_PRVOPC", "privileged opcode"},
+		6: {"ILL_PRVREG", "privileged register"},
+		7: {"ILL_COPROC", "coprocessor error"},
+		8: {"ILL_BADSTK", "internal stack error"},
+	},
+}
+
+// genericSignalCodes содержит значения si_code, общие для всех сигналов.
+var genericSignalCodes = map[int32]signalCode{
+	0:    {"SI_USER", "sent by kill"},
+	0x80: {"SI_KERNEL", "sent by the kernel"},
+	-1:   {"SI_QUEUE", "sent by sigqueue"},
+	-2:   {"SI_TIMER", "POSIX timer expired"},
+	-6:   {"SI_TKILL", "sent by tkill"},
+}
+
+// SignalCodeName возвращает имя и описание значения si_code <code> сигнала <sig>
+// или пустые строки, если значение неизвестно.
+func SignalCodeName(sig syscall.Signal, code int32) (string, string) {
+	// Положительные значения специфичны для сигнала, кроме SI_KERNEL.
+	if code > 0 && code != SignalKernel {
+		if c, ok := signalCodes[sig][code]; ok {
+			return c.name, c.description
+		}
+		return "", ""
+	}
+	if c, ok := genericSignalCodes[code]; ok {
+		return c.name, c.description
+	}
+	return "", ""
+}
@@ -0,0 +1,89 @@
+package system
+
+import (
+	"encoding/binary"
+	"fmt"
+	"syscall"
+)
+
+// SyscallName возвращает имя системного вызова по его номеру <nr>.
+func SyscallName(nr int) string {
+	if nr >= 0 && nr < len(syscallNames) && len(syscallNames[nr]) > 0 {
+		return syscallNames[nr]
+	}
+	return fmt.Sprintf("syscall_%d", nr)
+}
+
+// SyscallNumber возвращает номер системного вызова по его имени <name>.
+func SyscallNumber(name string) (int, bool) {
+	for nr, n := range syscallNames {
+		if len(n) > 0 && n == name {
+			return nr, true
+		}
+	}
+	return -1, false
+}
+
+// GetSyscallNumber возвращает номер системного вызова, на котором остановлен отслеживаемый процесс <pid>.
+func GetSyscallNumber(pid int) (int, error) {
+	var regs syscall.PtraceRegs
+	if err := syscall.PtraceGetRegs(pid, &regs); err != nil {
+		return -1, err
+	}
+	return syscallNumberFromRegs(&regs), nil
+}
+
+// SyscallState - номер, аргументы и результат системного вызова, на котором остановлен процесс.
+// Результат имеет смысл только при остановке на выходе из вызова.
+type SyscallState struct {
+	Nr     int
+	Args   [6]uint64
+	Result int64
+}
+
+// GetSyscallState возвращает состояние системного вызова, на котором остановлен отслеживаемый процесс <pid>.
+func GetSyscallState(pid int) (*SyscallState, error) {
+	var regs syscall.PtraceRegs
+	if err := syscall.PtraceGetRegs(pid, &regs); err != nil {
+		return nil, err
+	}
+	state := &SyscallState{Nr: syscallNumberFromRegs(&regs)}
+	state.Args, state.Result = syscallArgsFromRegs(&regs)
+	return state, nil
+}
+
+// ReadString читает строку, завершенную нулем, по адресу <addr> в памяти процесса <pid>
+// (PTRACE_PEEKDATA). Если строка длиннее <max> байт, возвращаются первые <max> байт и true.
+func ReadString(pid int, addr uint64, max int) (string, bool, error) {
+	var result []byte
+	var word [8]byte
+	for len(result) < max {
+		n, err := syscall.PtracePeekData(pid, uintptr(addr)+uintptr(len(result)), word[:])
+		if err != nil {
+			if len(result) > 0 {
+				// Строка в конце области памяти: следующее слово недоступно.
+				break
+			}
+			return "", false, err
+		}
+		for _, b := range word[:n] {
+			if b == 0 {
+				return string(result), false, nil
+			}
+			result = append(result, b)
+		}
+	}
+	if len(result) > max {
+		result = result[:max]
+	}
+	return string(result), true, nil
+}
+
+// ReadUint64 читает 64-битное значение по адресу <addr> в памяти процесса <pid> (PTRACE_PEEKDATA).
+func ReadUint64(pid int, addr uint64) (uint64, error) {
+	var word [8]byte
+	if _, err := syscall.PtracePeekData(pid, uintptr(addr), word[:]); err != nil {
+		return 0, err
+	}
+	return binary.LittleEndian.Uint64(word[:]), nil
+}
@@ -0,0 +1,26 @@
+//go:build !amd64
+// +build !amd64
+
+package system
+
+import "syscall"
+
+// Таблица системных вызовов есть только для x86-64, на остальных архитектурах
+// seccomp фильтр не поддерживается.
+const auditArch = 0
+
+var syscallNames = [...]string{}
+
+func syscallNumberFromRegs(regs *syscall.PtraceRegs) int {
+	return -1
+}
+
+// framePointerFromRegs возвращает адрес текущей инструкции; раскрутка стека по указателям
+// кадров поддерживается только на x86-64.
+func framePointerFromRegs(regs *syscall.PtraceRegs) (uint64, uint64) {
+	return uint64(regs.PC()), 0
+}
+
+func syscallArgsFromRegs(regs *syscall.PtraceRegs) ([6]uint64, int64) {
+	return [6]uint64{}, 0
+}